@@ -1,7 +1,7 @@
 #!/bin/bash
 
 minimal() {
-  # Minimal docker build
+  # Minimal docker build. Without cgo the sqlite sink is refused
   CGO_ENABLED=0 GOOS=linux GOARCH=amd64 go build -a -ldflags '-s' -installsuffix cgo -o skrape
 
 #  sudo docker build -t docker.mstry.io/etl/skrape -f Dockerfile .
@@ -0,0 +1,7 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// The sqlite driver needs cgo, binaries built without it can't use the sink
+const cgoEnabled = true
@@ -1,30 +1,92 @@
 package config
 
 import (
+	"time"
+
 	"github.com/MasteryConnect/skrape/lib/setup"
 	"github.com/aws/aws-sdk-go/aws"
 )
 
 type Config interface {
 	GetKinesis() *kinesis
+	GetFirehose() *firehose
+	GetKafka() *kafka
+	GetSqs() *sqs
+	GetDynamodb() *dynamodb
+	GetElasticsearch() *elasticsearch
+	GetS3() *s3
+	GetS3Key(kind, table, file string) string
+	GetPostgres() *postgres
+	GetMysqlTarget() *mysqlTarget
+	GetSqlite() *sqlite
+	GetStdout() *stdout
+	GetExec() *execCmd
+	GetHttp() *webhook
+	GetTee() *tee
+	GetDeadLetter() *deadLetter
+	GetTypes() *types
 	GetAws() *aws.Config
 	GetConn() *setup.Connection
+	GetRunID() string
 }
 
 type config struct {
-	Aws        *aws.Config
-	Kinesis    *kinesis
-	Connection *setup.Connection
+	Aws           *aws.Config
+	Kinesis       *kinesis
+	Firehose      *firehose
+	Kafka         *kafka
+	Sqs           *sqs
+	Dynamodb      *dynamodb
+	Elasticsearch *elasticsearch
+	S3            *s3
+	Postgres      *postgres
+	MysqlTarget   *mysqlTarget
+	Sqlite        *sqlite
+	Stdout        *stdout
+	Exec          *execCmd
+	Http          *webhook
+	Tee           *tee
+	DeadLetter    *deadLetter
+	Types         *types
+	Connection    *setup.Connection
+	RunID         string
+	Start         time.Time
 }
 
-func NewConfig(c *setup.Connection, region, kinesisStreamEndpoint, kinesisStreamName string, kinesisShardCount int) Config {
+// A blank runID is replaced by one generated from the start time
+func NewConfig(c *setup.Connection, runID string, a *aws.Config, k *kinesis, f *firehose, q *kafka, sq *sqs, d *dynamodb, es *elasticsearch, s *s3, p *postgres, m *mysqlTarget, l *sqlite, o *stdout, x *execCmd, h *webhook, t *tee, dl *deadLetter, ty *types) Config {
+	start := time.Now()
+	if runID == "" {
+		runID = start.UTC().Format("20060102T150405Z")
+	}
 	return &config{
-		Connection: c,
-		Aws:        NewAws(region),
-		Kinesis:    NewKinesis(kinesisStreamEndpoint, kinesisStreamName, kinesisShardCount),
+		Connection:    c,
+		RunID:         runID,
+		Start:         start,
+		Aws:           a,
+		Kinesis:       k,
+		Firehose:      f,
+		Kafka:         q,
+		Sqs:           sq,
+		Dynamodb:      d,
+		Elasticsearch: es,
+		S3:            s,
+		Postgres:      p,
+		MysqlTarget:   m,
+		Sqlite:        l,
+		Stdout:        o,
+		Exec:          x,
+		Http:          h,
+		Tee:           t,
+		DeadLetter:    dl,
+		Types:         ty,
 	}
 }
 
 func (c *config) GetConn() *setup.Connection {
 	return c.Connection
 }
+
+func (c *config) GetRunID() string {
+	return c.RunID
+}
@@ -0,0 +1,36 @@
+package config
+
+import "path/filepath"
+
+type deadLetter struct {
+	Dir        string
+	Upload     bool
+	MaxRejects int
+}
+
+func (c *config) GetDeadLetter() *deadLetter {
+	return c.DeadLetter
+}
+
+func NewDeadLetter(dir string, upload bool, maxRejects int) *deadLetter {
+	return &deadLetter{Dir: dir, Upload: upload, MaxRejects: maxRejects}
+}
+
+// File the rejected rows of a table are written to
+func (d *deadLetter) GetPath(table string) string {
+	return filepath.Join(d.Dir, table+".jsonl")
+}
+
+func (d *deadLetter) IsEnabled() bool {
+	return d.Dir != ""
+}
+
+// Whether dead letter files are uploaded to S3 after each table
+func (d *deadLetter) IsUpload() bool {
+	return d.Upload
+}
+
+// Rows a table may reject before it fails, negative for no limit
+func (d *deadLetter) GetMaxRejects() int {
+	return d.MaxRejects
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"strings"
+)
+
+type dynamodb struct {
+	Endpoint    string
+	Table       string
+	CreateTable bool
+}
+
+func (c *config) GetDynamodb() *dynamodb {
+	return c.Dynamodb
+}
+
+func NewDynamodb(endpoint, table string, createTable bool) *dynamodb {
+	return &dynamodb{
+		Endpoint:    endpoint,
+		Table:       table,
+		CreateTable: createTable,
+	}
+}
+
+func (d *dynamodb) GetEndpoint() string {
+	return d.Endpoint
+}
+
+// DynamoDB table of a MySQL table, {TABLE_NAME} is replaced like for Kinesis
+func (d *dynamodb) GetTable(table string) string {
+	if d.Table == "" {
+		return table
+	}
+	return strings.Replace(d.Table, REPLACE, table, -1)
+}
+
+func (d *dynamodb) IsCreateTable() bool {
+	return d.CreateTable
+}
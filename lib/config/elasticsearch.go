@@ -0,0 +1,74 @@
+package config
+
+import (
+	"strings"
+)
+
+const (
+	DefaultEsIndex    = REPLACE
+	DefaultEsBulkSize = 1000
+)
+
+type elasticsearch struct {
+	URL         string
+	Index       string
+	Alias       string
+	CreateIndex bool
+	BulkSize    int
+	DeleteOld   bool
+}
+
+func (c *config) GetElasticsearch() *elasticsearch {
+	return c.Elasticsearch
+}
+
+func NewElasticsearch(url, index, alias string, createIndex bool, bulkSize int, deleteOld bool) *elasticsearch {
+	if index == "" {
+		index = DefaultEsIndex
+	}
+	if bulkSize <= 0 {
+		bulkSize = DefaultEsBulkSize
+	}
+	return &elasticsearch{
+		URL:         strings.TrimSuffix(url, "/"),
+		Index:       index,
+		Alias:       alias,
+		CreateIndex: createIndex,
+		BulkSize:    bulkSize,
+		DeleteOld:   deleteOld,
+	}
+}
+
+func (e *elasticsearch) GetURL() string {
+	return e.URL
+}
+
+// Index a table is written to. {TABLE_NAME} and {RUN_ID} are
+// replaced, and with an alias every run gets its own index.
+func (e *elasticsearch) GetIndex(table, runID string) string {
+	index := e.Index
+	if e.Alias != "" && !strings.Contains(index, KeyRunID) {
+		index += "-" + KeyRunID
+	}
+	index = strings.Replace(index, REPLACE, table, -1)
+	index = strings.Replace(index, KeyRunID, runID, -1)
+	return strings.ToLower(index) // index names must be lowercase
+}
+
+// Alias swapped to the new index, empty when not swapping
+func (e *elasticsearch) GetAlias(table string) string {
+	return strings.ToLower(strings.Replace(e.Alias, REPLACE, table, -1))
+}
+
+func (e *elasticsearch) IsCreateIndex() bool {
+	return e.CreateIndex
+}
+
+func (e *elasticsearch) GetBulkSize() int {
+	return e.BulkSize
+}
+
+// Whether indices the alias pointed at before are deleted after the swap
+func (e *elasticsearch) IsDeleteOld() bool {
+	return e.DeleteOld
+}
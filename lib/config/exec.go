@@ -0,0 +1,30 @@
+package config
+
+type execCmd struct {
+	Command string
+	Format  string
+}
+
+func (c *config) GetExec() *execCmd {
+	return c.Exec
+}
+
+func NewExec(command, format string) *execCmd {
+	if format == "" {
+		format = FormatCsv
+	}
+	return &execCmd{
+		Command: command,
+		Format:  format,
+	}
+}
+
+// Shell command started for every table
+func (e *execCmd) GetCommand() string {
+	return e.Command
+}
+
+// Format of the rows written to the command's stdin
+func (e *execCmd) GetFormat() string {
+	return e.Format
+}
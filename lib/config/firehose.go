@@ -0,0 +1,30 @@
+package config
+
+import (
+	"strings"
+)
+
+type firehose struct {
+	Endpoint   string
+	StreamName string
+}
+
+func (c *config) GetFirehose() *firehose {
+	return c.Firehose
+}
+
+func NewFirehose(endpoint, name string) *firehose {
+	return &firehose{
+		Endpoint:   endpoint,
+		StreamName: name,
+	}
+}
+
+func (f *firehose) GetEndpoint() string {
+	return f.Endpoint
+}
+
+// Delivery stream of a table, {TABLE_NAME} is replaced like for Kinesis
+func (f *firehose) GetStream(table string) string {
+	return strings.Replace(f.StreamName, REPLACE, table, -1)
+}
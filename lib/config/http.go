@@ -0,0 +1,95 @@
+package config
+
+import (
+	"strings"
+)
+
+const (
+	DefaultHttpBatchSize  = 500
+	DefaultHttpBatchBytes = 5 // MB
+	DefaultHttpRetries    = 5
+)
+
+type webhook struct {
+	URL        string
+	BatchSize  int // rows per request
+	BatchBytes int // MB of JSON per request before compression
+	Gzip       bool
+	Headers    []string // "Name: value"
+	Token      string
+	BasicAuth  string // user:password
+	MaxRetries int
+}
+
+func (c *config) GetHttp() *webhook {
+	return c.Http
+}
+
+func NewHttp(url string, batchSize, batchBytes int, gzip bool, headers []string, token, basicAuth string, maxRetries int) *webhook {
+	if batchSize <= 0 {
+		batchSize = DefaultHttpBatchSize
+	}
+	if batchBytes <= 0 {
+		batchBytes = DefaultHttpBatchBytes
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
+	return &webhook{
+		URL:        url,
+		BatchSize:  batchSize,
+		BatchBytes: batchBytes,
+		Gzip:       gzip,
+		Headers:    headers,
+		Token:      token,
+		BasicAuth:  basicAuth,
+		MaxRetries: maxRetries,
+	}
+}
+
+// URL batches of a table are posted to, {TABLE_NAME} is replaced like for Kinesis
+func (w *webhook) GetURL(table string) string {
+	return strings.Replace(w.URL, REPLACE, table, -1)
+}
+
+func (w *webhook) GetBatchSize() int {
+	return w.BatchSize
+}
+
+// Size limit of a batch in bytes
+func (w *webhook) GetBatchBytes() int {
+	return w.BatchBytes * 1024 * 1024
+}
+
+func (w *webhook) IsGzip() bool {
+	return w.Gzip
+}
+
+// Extra request headers split into name and value
+func (w *webhook) GetHeaders() map[string]string {
+	headers := map[string]string{}
+	for _, h := range w.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) == 2 {
+			headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return headers
+}
+
+func (w *webhook) GetToken() string {
+	return w.Token
+}
+
+// User and password for basic auth, empty when not set
+func (w *webhook) GetBasicAuth() (string, string) {
+	parts := strings.SplitN(w.BasicAuth, ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+func (w *webhook) GetMaxRetries() int {
+	return w.MaxRetries
+}
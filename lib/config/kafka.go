@@ -0,0 +1,102 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// Acknowledgements the producer waits for
+const (
+	KafkaAcksNone   = "none"   // don't wait
+	KafkaAcksLeader = "leader" // the partition leader wrote it
+	KafkaAcksAll    = "all"    // every in-sync replica wrote it
+)
+
+var KafkaAcks = []string{KafkaAcksNone, KafkaAcksLeader, KafkaAcksAll}
+
+var KafkaCompressions = []string{"none", "gzip", "snappy", "lz4", "zstd"}
+
+type kafka struct {
+	Brokers     []string
+	Topic       string
+	Acks        string
+	Compression string
+	BatchSize   int // messages per produce request
+	Linger      int // ms a batch may wait to fill up
+	Idempotent  bool
+	CreateTopic bool
+	Partitions  int
+	Replication int
+}
+
+func (c *config) GetKafka() *kafka {
+	return c.Kafka
+}
+
+func NewKafka(brokers []string, topic, acks, compression string, batchSize, linger int, idempotent, createTopic bool, partitions, replication int) *kafka {
+	if acks == "" {
+		acks = KafkaAcksAll
+	}
+	if compression == "" {
+		compression = "none"
+	}
+	if partitions <= 0 {
+		partitions = 1
+	}
+	if replication <= 0 {
+		replication = 1
+	}
+	return &kafka{
+		Brokers:     brokers,
+		Topic:       topic,
+		Acks:        acks,
+		Compression: compression,
+		BatchSize:   batchSize,
+		Linger:      linger,
+		Idempotent:  idempotent,
+		CreateTopic: createTopic,
+		Partitions:  partitions,
+		Replication: replication,
+	}
+}
+
+func (k *kafka) GetBrokers() []string {
+	return k.Brokers
+}
+
+// Topic of a table, {TABLE_NAME} is replaced like for Kinesis
+func (k *kafka) GetTopic(table string) string {
+	return strings.Replace(k.Topic, REPLACE, table, -1)
+}
+
+func (k *kafka) GetAcks() string {
+	return k.Acks
+}
+
+func (k *kafka) GetCompression() string {
+	return k.Compression
+}
+
+func (k *kafka) GetBatchSize() int {
+	return k.BatchSize
+}
+
+func (k *kafka) GetLinger() time.Duration {
+	return time.Duration(k.Linger) * time.Millisecond
+}
+
+func (k *kafka) IsIdempotent() bool {
+	return k.Idempotent
+}
+
+func (k *kafka) IsCreateTopic() bool {
+	return k.CreateTopic
+}
+
+func (k *kafka) GetPartitions() int {
+	return k.Partitions
+}
+
+func (k *kafka) GetReplication() int {
+	return k.Replication
+}
@@ -1,26 +1,68 @@
 package config
 
 import (
+	"fmt"
 	"strings"
 )
 
 type kinesis struct {
-	Endpoint   string
-	StreamName string
-	ShardCount int
+	Endpoint      string
+	StreamName    string
+	ShardCount    int
+	Aggregate     bool
+	PartitionKeys []string
+	MaxRetries    int
+	RateLimit     bool
+	Reshard       bool
+	MBPerShard    int
+	Retention     int // hours
+	KmsKey        string
+	Tags          []string
+	Oversize      string
+	TruncateCols  []string
 }
 
+// What happens to a row too large for a single Kinesis record
+const (
+	OversizeFail       = "fail"        // fail the table
+	OversizeSplit      = "split"       // send it as ordered chunks
+	OversizeS3         = "s3"          // upload it to S3 and send a pointer
+	OversizeTruncate   = "truncate"    // shorten the truncate columns
+	OversizeDeadLetter = "dead-letter" // write it to the dead letter file
+)
+
+var OversizePolicies = []string{OversizeFail, OversizeSplit, OversizeS3, OversizeTruncate, OversizeDeadLetter}
+
 const REPLACE = "{TABLE_NAME}"
 
 func (c *config) GetKinesis() *kinesis {
 	return c.Kinesis
 }
 
-func NewKinesis(endpoint, name string, shardCount int) *kinesis {
+const DefaultKinesisRetries = 10
+
+func NewKinesis(endpoint, name string, shardCount int, aggregate bool, partitionKeys []string, maxRetries int, rateLimit, reshard bool, mbPerShard, retention int, kmsKey string, tags []string, oversize string, truncateCols []string) *kinesis {
+	if oversize == "" {
+		oversize = OversizeFail
+	}
+	if maxRetries < 0 {
+		maxRetries = 0
+	}
 	return &kinesis{
-		Endpoint:   endpoint,
-		StreamName: name,
-		ShardCount: shardCount,
+		Endpoint:      endpoint,
+		StreamName:    name,
+		ShardCount:    shardCount,
+		Aggregate:     aggregate,
+		PartitionKeys: partitionKeys,
+		MaxRetries:    maxRetries,
+		RateLimit:     rateLimit,
+		Reshard:       reshard,
+		MBPerShard:    mbPerShard,
+		Retention:     retention,
+		KmsKey:        kmsKey,
+		Tags:          tags,
+		Oversize:      oversize,
+		TruncateCols:  truncateCols,
 	}
 }
 
@@ -32,6 +74,67 @@ func (k *kinesis) GetShardCount() int {
 	return k.ShardCount
 }
 
+// Times a throttled or failed record is retried before
+// it goes to the dead letter file
+func (k *kinesis) GetMaxRetries() int {
+	return k.MaxRetries
+}
+
+// Whether puts are held to the per-shard write limits
+func (k *kinesis) IsRateLimit() bool {
+	return k.RateLimit
+}
+
+// Whether existing streams are resharded to the shard count
+func (k *kinesis) IsReshard() bool {
+	return k.Reshard
+}
+
+// Table data in MB a shard is added for, 0 when the
+// shard count doesn't follow the table size
+func (k *kinesis) GetMBPerShard() int {
+	return k.MBPerShard
+}
+
+// Retention period in hours of created streams
+func (k *kinesis) GetRetention() int {
+	return k.Retention
+}
+
+// KMS key created streams are encrypted with, empty for none
+func (k *kinesis) GetKmsKey() string {
+	return k.KmsKey
+}
+
+// Tags of created streams split into key and value
+func (k *kinesis) GetTags() map[string]string {
+	tags := map[string]string{}
+	for _, t := range k.Tags {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) == 2 {
+			tags[parts[0]] = parts[1]
+		} else {
+			tags[parts[0]] = ""
+		}
+	}
+	return tags
+}
+
+// Policy for rows too large for a Kinesis record
+func (k *kinesis) GetOversize() string {
+	return k.Oversize
+}
+
+// Columns the truncate policy may shorten, in the order tried
+func (k *kinesis) GetTruncateColumns() []string {
+	return k.TruncateCols
+}
+
+// Whether rows are packed into KPL aggregated records
+func (k *kinesis) IsAggregate() bool {
+	return k.Aggregate
+}
+
 func (k *kinesis) GetStream(table string) string {
 	if strings.Contains(k.StreamName, REPLACE) {
 		return strings.Replace(k.StreamName, REPLACE, table, -1)
@@ -39,3 +142,72 @@ func (k *kinesis) GetStream(table string) string {
 		return k.StreamName
 	}
 }
+
+// Partition key strategies of the Kinesis sink
+const (
+	PartitionPrimary    = "primary"     // primary key values, composite keys joined
+	PartitionColumn     = "column"      // column:<name>
+	PartitionExpr       = "expr"        // expr:<template>, e.g. expr:{account_id}/{id}
+	PartitionRandom     = "random"      // random key, rows spread evenly
+	PartitionRoundRobin = "round-robin" // each record goes to the next shard
+)
+
+var PartitionStrategies = []string{PartitionPrimary, PartitionColumn, PartitionExpr, PartitionRandom, PartitionRoundRobin}
+
+// Partition key strategy of a table. An entry of the form
+// <table>=<strategy> applies to that table, one without a
+// table name to every other table.
+func (k *kinesis) GetPartitionKey(table string) string {
+	strategy := PartitionPrimary
+	for _, entry := range k.PartitionKeys {
+		name, spec := splitPartitionEntry(entry)
+		if name == table {
+			return spec
+		}
+		if name == "" {
+			strategy = spec
+		}
+	}
+	return strategy
+}
+
+// Break a partition key strategy into its name and argument
+func ParsePartitionKey(spec string) (string, string, error) {
+	strategy, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		strategy, arg = spec[:i], spec[i+1:]
+	}
+	switch strategy {
+	case PartitionColumn, PartitionExpr:
+		if arg == "" {
+			return "", "", fmt.Errorf("partition key %q needs a value after the colon", spec)
+		}
+	case PartitionPrimary, PartitionRandom, PartitionRoundRobin:
+		if arg != "" {
+			return "", "", fmt.Errorf("partition key %q takes no value", spec)
+		}
+	default:
+		return "", "", fmt.Errorf("unknown partition key %q, expected one of %s", spec, strings.Join(PartitionStrategies, ", "))
+	}
+	return strategy, arg, nil
+}
+
+// Validate every entry of the partition key setting
+func CheckPartitionKeys(entries []string) error {
+	for _, entry := range entries {
+		_, spec := splitPartitionEntry(entry)
+		if _, _, err := ParsePartitionKey(spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Table names can't hold a colon, strategies before the = always do
+func splitPartitionEntry(entry string) (string, string) {
+	i := strings.Index(entry, "=")
+	if i < 0 || strings.Contains(entry[:i], ":") {
+		return "", entry
+	}
+	return entry[:i], entry[i+1:]
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// How rows are written to the target MySQL server
+const (
+	MysqlLoad   = "load"   // stream rows through LOAD DATA LOCAL INFILE
+	MysqlInsert = "insert" // batched multi-row INSERT statements
+)
+
+var MysqlMethods = []string{MysqlLoad, MysqlInsert}
+
+// Target server for the mysql sink. Kept apart from
+// setup.Connection which always points at the source.
+type mysqlTarget struct {
+	Host        string
+	Port        string
+	User        string
+	Password    string
+	Database    string
+	Method      string
+	BatchSize   int
+	Recreate    bool
+	Truncate    bool
+	DisableKeys bool
+}
+
+func (c *config) GetMysqlTarget() *mysqlTarget {
+	return c.MysqlTarget
+}
+
+func NewMysqlTarget(host, port, user, password, database, method string, batchSize int, recreate, truncate, disableKeys bool) *mysqlTarget {
+	if port == "" {
+		port = "3306"
+	}
+	if method == "" {
+		method = MysqlLoad
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	return &mysqlTarget{
+		Host:        host,
+		Port:        port,
+		User:        user,
+		Password:    password,
+		Database:    database,
+		Method:      method,
+		BatchSize:   batchSize,
+		Recreate:    recreate,
+		Truncate:    truncate,
+		DisableKeys: disableKeys,
+	}
+}
+
+// DSN for the target server
+func (m *mysqlTarget) GetDsn() string {
+	dsn := mysql.NewConfig() // keeps the driver defaults, e.g. native passwords
+	dsn.User = m.User
+	dsn.Passwd = m.Password
+	dsn.Net = "tcp"
+	dsn.Addr = net.JoinHostPort(m.Host, m.Port)
+	dsn.DBName = m.Database
+	dsn.Params = map[string]string{
+		"charset":   "utf8",
+		"time_zone": "'+00:00'", // mysqldump writes TIMESTAMP values in UTC
+	}
+	return dsn.FormatDSN()
+}
+
+func (m *mysqlTarget) GetMethod() string {
+	return m.Method
+}
+
+func (m *mysqlTarget) GetBatchSize() int {
+	return m.BatchSize
+}
+
+func (m *mysqlTarget) IsRecreate() bool {
+	return m.Recreate
+}
+
+func (m *mysqlTarget) IsTruncate() bool {
+	return m.Truncate
+}
+
+func (m *mysqlTarget) IsDisableKeys() bool {
+	return m.DisableKeys
+}
@@ -0,0 +1,46 @@
+package config
+
+// How rows are loaded into the target PostgreSQL table
+const (
+	PgTruncate = "truncate" // empty the table and load in one transaction
+	PgStaging  = "staging"  // load a staging table then swap it in
+	PgAppend   = "append"   // add the rows to the existing table
+)
+
+var PgModes = []string{PgTruncate, PgStaging, PgAppend}
+
+type postgres struct {
+	URL    string
+	Schema string
+	Mode   string
+}
+
+func (c *config) GetPostgres() *postgres {
+	return c.Postgres
+}
+
+func NewPostgres(url, schema, mode string) *postgres {
+	if schema == "" {
+		schema = "public"
+	}
+	if mode == "" {
+		mode = PgTruncate
+	}
+	return &postgres{
+		URL:    url,
+		Schema: schema,
+		Mode:   mode,
+	}
+}
+
+func (p *postgres) GetURL() string {
+	return p.URL
+}
+
+func (p *postgres) GetSchema() string {
+	return p.Schema
+}
+
+func (p *postgres) GetMode() string {
+	return p.Mode
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+const (
+	DefaultPartSize          = 16 // MB per multipart upload part
+	DefaultUploadConcurrency = 5  // parts uploaded in parallel
+	DefaultMaxRetries        = 5  // retries per part before the upload fails
+	DefaultKeyTemplate       = "{DATE}/{TYPE}"
+)
+
+// Placeholders available in S3 key templates
+const (
+	KeyDB    = "{DB}"     // database name
+	KeyTable = "{TABLE}"  // table name
+	KeyDate  = "{DATE}"   // run date in UTC as YYYY/MM/DD
+	KeyDT    = "{DT}"     // Hive style partition, dt=YYYY-MM-DD
+	KeyYear  = "{YEAR}"   // run year as YYYY
+	KeyMonth = "{MONTH}"  // run month as MM
+	KeyDay   = "{DAY}"    // run day as DD
+	KeyRunID = "{RUN_ID}" // id shared by every object in a run
+	KeyType  = "{TYPE}"   // object type: data, schemas, paths, ddl or manifests
+	KeyFile  = "{FILE}"   // object file name, appended when missing from the template
+)
+
+type s3 struct {
+	Bucket      string
+	Prefix      string
+	KeyTemplate string
+	Manifest    bool
+	DDL         []string
+	Stream      bool
+	PartSize    int
+	Concurrency int
+	MaxRetries  int
+}
+
+func (c *config) GetS3() *s3 {
+	return c.S3
+}
+
+// Zero values fall back to the defaults so the S3 settings
+// work when no s3 command flags were given. Retries can be
+// turned off with 0, only a negative count falls back.
+func NewS3(bucket, prefix, keyTemplate string, manifest bool, ddl []string, stream bool, partSize, concurrency, maxRetries int) *s3 {
+	if keyTemplate == "" {
+		keyTemplate = DefaultKeyTemplate
+	}
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+	if maxRetries < 0 {
+		maxRetries = DefaultMaxRetries
+	}
+	return &s3{
+		Bucket:      bucket,
+		Prefix:      prefix,
+		KeyTemplate: keyTemplate,
+		Manifest:    manifest,
+		DDL:         ddl,
+		Stream:      stream,
+		PartSize:    partSize,
+		Concurrency: concurrency,
+		MaxRetries:  maxRetries,
+	}
+}
+
+func (s *s3) GetBucket() string {
+	return s.Bucket
+}
+
+func (s *s3) IsManifest() bool {
+	return s.Manifest
+}
+
+// Dialects to upload CREATE TABLE statements for
+func (s *s3) GetDDL() []string {
+	return s.DDL
+}
+
+func (s *s3) IsStream() bool {
+	return s.Stream
+}
+
+// Part size in bytes
+func (s *s3) GetPartSize() int64 {
+	return int64(s.PartSize) * 1024 * 1024
+}
+
+func (s *s3) GetConcurrency() int {
+	return s.Concurrency
+}
+
+func (s *s3) GetMaxRetries() int {
+	return s.MaxRetries
+}
+
+// Build the S3 key for an object of the given type by filling in
+// the key template and putting it under the prefix. The file name
+// is appended unless the template places it with {FILE}. Dates
+// are in UTC like the run id.
+func (c *config) GetS3Key(kind, table, file string) string {
+	s := c.S3
+	start := c.Start.UTC()
+	tmpl := s.KeyTemplate
+	if !strings.Contains(tmpl, KeyFile) {
+		tmpl = tmpl + "/" + KeyFile
+	}
+	replacer := strings.NewReplacer(
+		KeyDB, c.Connection.Database,
+		KeyTable, table,
+		KeyDate, start.Format("2006/01/02"),
+		KeyDT, "dt="+start.Format("2006-01-02"),
+		KeyYear, start.Format("2006"),
+		KeyMonth, start.Format("01"),
+		KeyDay, start.Format("02"),
+		KeyRunID, c.RunID,
+		KeyType, kind,
+		KeyFile, file,
+	)
+	// path.Join drops the empty segments left by blank placeholders
+	key := path.Join(s.Prefix, replacer.Replace(tmpl))
+	return strings.TrimPrefix(key, "/")
+}
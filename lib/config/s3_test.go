@@ -0,0 +1,65 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/setup"
+)
+
+func TestGetS3Key(t *testing.T) {
+	// late on the 5th in New York is the 6th in UTC
+	start := time.Date(2024, 3, 5, 23, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	tests := []struct {
+		name     string
+		prefix   string
+		template string
+		kind     string
+		table    string
+		want     string
+	}{
+		{"default template", "", "", "data", "users", "2024/03/06/data/users.csv"},
+		{"prefix", "exports/", "", "schemas", "users", "exports/2024/03/06/schemas/users.csv"},
+		{"every placeholder", "p", "{DB}/{TABLE}/{DT}/{YEAR}-{MONTH}-{DAY}/{RUN_ID}/{TYPE}/{FILE}", "data", "users", "p/shop/users/dt=2024-03-06/2024-03-06/run-1/data/users.csv"},
+		{"file placed in the template", "", "{FILE}/{TYPE}", "ddl", "users", "users.csv/ddl"},
+		{"blank table dropped", "", "{TYPE}/{TABLE}", "manifests", "", "manifests/users.csv"},
+		{"leading slash trimmed", "", "/{DB}", "data", "users", "shop/users.csv"},
+		{"literal text kept", "", "db={DB}/{PART}", "data", "users", "db=shop/{PART}/users.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &config{
+				S3:         NewS3("bucket", tt.prefix, tt.template, false, nil, false, 0, 0, -1),
+				Connection: &setup.Connection{Database: "shop"},
+				RunID:      "run-1",
+				Start:      start,
+			}
+			if got := c.GetS3Key(tt.kind, tt.table, "users.csv"); got != tt.want {
+				t.Errorf("GetS3Key(%q, %q) with %q = %q, want %q", tt.kind, tt.table, tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewS3Defaults(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		want       int
+	}{
+		{"unset", -1, DefaultMaxRetries},
+		{"no retries", 0, 0},
+		{"set", 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewS3("", "", "", false, nil, false, 0, 0, tt.maxRetries)
+			if s.GetMaxRetries() != tt.want {
+				t.Errorf("max retries %d became %d, want %d", tt.maxRetries, s.GetMaxRetries(), tt.want)
+			}
+			if s.KeyTemplate != DefaultKeyTemplate || s.GetPartSize() != DefaultPartSize*1024*1024 || s.GetConcurrency() != DefaultUploadConcurrency {
+				t.Errorf("zero values did not fall back to the defaults: %+v", s)
+			}
+		})
+	}
+}
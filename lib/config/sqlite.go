@@ -0,0 +1,33 @@
+package config
+
+const DefaultSqliteBatchSize = 100000 // rows per transaction
+
+type sqlite struct {
+	Path      string
+	BatchSize int
+}
+
+func (c *config) GetSqlite() *sqlite {
+	return c.Sqlite
+}
+
+func NewSqlite(path string, batchSize int) *sqlite {
+	if path == "" {
+		path = "skrape.db"
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultSqliteBatchSize
+	}
+	return &sqlite{
+		Path:      path,
+		BatchSize: batchSize,
+	}
+}
+
+func (s *sqlite) GetPath() string {
+	return s.Path
+}
+
+func (s *sqlite) GetBatchSize() int {
+	return s.BatchSize
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"strings"
+)
+
+type sqs struct {
+	Endpoint       string
+	Queue          string
+	GroupColumn    string
+	RowsPerMessage int
+}
+
+func (c *config) GetSqs() *sqs {
+	return c.Sqs
+}
+
+func NewSqs(endpoint, queue, groupColumn string, rowsPerMessage int) *sqs {
+	if rowsPerMessage <= 0 {
+		rowsPerMessage = 1
+	}
+	return &sqs{
+		Endpoint:       endpoint,
+		Queue:          queue,
+		GroupColumn:    groupColumn,
+		RowsPerMessage: rowsPerMessage,
+	}
+}
+
+func (q *sqs) GetEndpoint() string {
+	return q.Endpoint
+}
+
+// Queue name or URL of a table, {TABLE_NAME} is replaced like for Kinesis
+func (q *sqs) GetQueue(table string) string {
+	return strings.Replace(q.Queue, REPLACE, table, -1)
+}
+
+// Column whose value becomes the message group id of a FIFO queue
+func (q *sqs) GetGroupColumn() string {
+	return q.GroupColumn
+}
+
+func (q *sqs) GetRowsPerMessage() int {
+	return q.RowsPerMessage
+}
@@ -0,0 +1,28 @@
+package config
+
+// Row formats for sinks that write text streams
+const (
+	FormatCsv   = "csv"
+	FormatJsonl = "jsonl"
+)
+
+var Formats = []string{FormatCsv, FormatJsonl}
+
+type stdout struct {
+	Format string
+}
+
+func (c *config) GetStdout() *stdout {
+	return c.Stdout
+}
+
+func NewStdout(format string) *stdout {
+	if format == "" {
+		format = FormatCsv
+	}
+	return &stdout{Format: format}
+}
+
+func (s *stdout) GetFormat() string {
+	return s.Format
+}
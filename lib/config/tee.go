@@ -0,0 +1,27 @@
+package config
+
+type tee struct {
+	Sinks    []string
+	FailFast bool
+}
+
+func (c *config) GetTee() *tee {
+	return c.Tee
+}
+
+func NewTee(sinks []string, failFast bool) *tee {
+	return &tee{
+		Sinks:    sinks,
+		FailFast: failFast,
+	}
+}
+
+// Sink types every row is fed to
+func (t *tee) GetSinks() []string {
+	return t.Sinks
+}
+
+// Whether one failing sink aborts the table for all of them
+func (t *tee) IsFailFast() bool {
+	return t.FailFast
+}
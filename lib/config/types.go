@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+type types struct {
+	Decimals string
+	Json     string
+	Timezone string
+	Columns  []string // [table.]column=kind
+}
+
+func (c *config) GetTypes() *types {
+	return c.Types
+}
+
+func NewTypes(decimals, json, timezone string, columns []string) *types {
+	if decimals == "" {
+		decimals = mysqlutils.KindDecimal
+	}
+	if json == "" {
+		json = mysqlutils.KindJson
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return &types{
+		Decimals: decimals,
+		Json:     json,
+		Timezone: timezone,
+		Columns:  columns,
+	}
+}
+
+// The mapping typed records are built with
+func (t *types) TypeMap() *mysqlutils.TypeMap {
+	loc, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	tm := &mysqlutils.TypeMap{
+		Decimal:  t.Decimals,
+		Json:     t.Json,
+		Location: loc,
+		Columns:  map[string]string{},
+	}
+	for _, entry := range t.Columns {
+		column, kind, _ := splitColumnType(entry)
+		tm.Columns[column] = kind
+	}
+	return tm
+}
+
+// Validate the decimal and JSON kinds, time zone and column kinds
+func (t *types) Check() error {
+	if !utility.StringInSlice(t.Decimals, mysqlutils.DecimalKinds) {
+		return fmt.Errorf("unknown --decimals %s, expected one of %s", t.Decimals, strings.Join(mysqlutils.DecimalKinds, ", "))
+	}
+	if !utility.StringInSlice(t.Json, mysqlutils.JsonKinds) {
+		return fmt.Errorf("unknown --json-columns %s, expected one of %s", t.Json, strings.Join(mysqlutils.JsonKinds, ", "))
+	}
+	if _, err := time.LoadLocation(t.Timezone); err != nil {
+		return fmt.Errorf("unknown --timezone %s", t.Timezone)
+	}
+	for _, entry := range t.Columns {
+		if _, _, err := splitColumnType(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitColumnType(entry string) (string, string, error) {
+	i := strings.LastIndex(entry, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("column type %q is not [table.]column=kind", entry)
+	}
+	column, kind := entry[:i], entry[i+1:]
+	if !utility.StringInSlice(kind, mysqlutils.Kinds) {
+		return "", "", fmt.Errorf("unknown kind %q for column %s, expected one of %s", kind, column, strings.Join(mysqlutils.Kinds, ", "))
+	}
+	return column, kind, nil
+}
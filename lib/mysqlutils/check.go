@@ -94,7 +94,7 @@ func printWarning() {
  using the --mysqldump-path flag to point to where the binary
  is located.
 `
-	fmt.Println(str)
+	fmt.Fprintln(os.Stderr, str)
 	os.Exit(1)
 }
 
@@ -0,0 +1,241 @@
+package mysqlutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+// Warehouse dialects DDL can be generated for
+const (
+	Redshift = "redshift"
+	Postgres = "postgres"
+	Sqlite   = "sqlite"
+)
+
+const (
+	RedshiftMaxVarchar   = 65535 // bytes
+	RedshiftMaxPrecision = 38
+	MysqlMaxCharBytes    = 4 // utf8mb4, Redshift varchar lengths are in bytes
+)
+
+var Dialects = []string{Redshift, Postgres, Sqlite}
+
+// Generate a CREATE TABLE statement for the table in the given dialect
+func (s *Schema) DDL(dialect, table string) (string, error) {
+	return s.CreateTable(dialect, QuoteIdent(table))
+}
+
+// Generate a CREATE TABLE statement for an already quoted,
+// possibly schema qualified, table identifier
+func (s *Schema) CreateTable(dialect, ident string) (string, error) {
+	if !utility.StringInSlice(dialect, Dialects) {
+		return "", fmt.Errorf("unknown DDL dialect %q, expected one of %s", dialect, strings.Join(Dialects, ", "))
+	}
+	var cols []string
+	for _, f := range s.Fields {
+		colType, err := columnType(dialect, f)
+		if err != nil {
+			return "", fmt.Errorf("%s.%s: %s", ident, f.Name, err)
+		}
+		col := fmt.Sprintf("  %s %s", QuoteIdent(f.Name), colType)
+		if !f.Nullable() {
+			col += " NOT NULL"
+		}
+		cols = append(cols, col)
+	}
+	if keys := s.PrimaryKey(); len(keys) > 0 {
+		for i, k := range keys {
+			keys[i] = QuoteIdent(k)
+		}
+		cols = append(cols, fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(keys, ", ")))
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n);\n", ident, strings.Join(cols, ",\n")), nil
+}
+
+func columnType(dialect string, f Field) (string, error) {
+	if dialect == Sqlite {
+		return SqliteType(f), nil
+	}
+	return WarehouseType(dialect, f)
+}
+
+// Map a MySQL column to the SQLite type affinity that keeps its values
+func SqliteType(f Field) string {
+	switch f.ColumnType().Base {
+	case "tinyint", "bool", "boolean", "smallint", "mediumint", "int", "integer", "bigint", "bit", "year":
+		return "INTEGER"
+	case "decimal", "numeric", "fixed", "dec":
+		return "NUMERIC"
+	case "float", "double", "real":
+		return "REAL"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "BLOB"
+	}
+	return "TEXT" // spatial columns included, they are written as WKT
+}
+
+// Map a MySQL column to the matching column type of the dialect
+func WarehouseType(dialect string, f Field) (string, error) {
+	ct := f.ColumnType()
+	redshift := dialect == Redshift
+
+	switch ct.Base {
+	case "tinyint":
+		if ct.arg(0) == 1 {
+			return "boolean", nil
+		}
+		return "smallint", nil
+	case "bool", "boolean":
+		return "boolean", nil
+	case "smallint":
+		if ct.Unsigned {
+			return "integer", nil
+		}
+		return "smallint", nil
+	case "mediumint":
+		return "integer", nil
+	case "int", "integer":
+		if ct.Unsigned {
+			return "bigint", nil
+		}
+		return "integer", nil
+	case "bigint":
+		if ct.Unsigned {
+			return "numeric(20,0)", nil
+		}
+		return "bigint", nil
+	case "decimal", "numeric", "fixed", "dec":
+		precision, scale := ct.arg(0), ct.arg(1)
+		if precision == 0 {
+			precision = 10 // MySQL default
+		}
+		if redshift && precision > RedshiftMaxPrecision {
+			// too wide for Redshift, keep every digit as text
+			return fmt.Sprintf("varchar(%d)", precision+2), nil
+		}
+		return fmt.Sprintf("numeric(%d,%d)", precision, scale), nil
+	case "float":
+		if ct.arg(0) > 24 {
+			return "double precision", nil
+		}
+		return "real", nil
+	case "double", "real":
+		return "double precision", nil
+	case "bit":
+		if ct.arg(0) <= 1 {
+			return "boolean", nil
+		}
+		return "bigint", nil
+	case "char":
+		length := ct.arg(0)
+		if length == 0 {
+			length = 1
+		}
+		if redshift {
+			return redshiftVarchar(length), nil
+		}
+		return fmt.Sprintf("char(%d)", length), nil
+	case "varchar":
+		if redshift {
+			return redshiftVarchar(ct.arg(0)), nil
+		}
+		return fmt.Sprintf("varchar(%d)", ct.arg(0)), nil
+	case "tinytext":
+		if redshift {
+			return "varchar(255)", nil
+		}
+		return "text", nil
+	case "text", "mediumtext", "longtext":
+		if redshift {
+			return fmt.Sprintf("varchar(%d)", RedshiftMaxVarchar), nil
+		}
+		return "text", nil
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		if redshift {
+			return fmt.Sprintf("varchar(%d)", RedshiftMaxVarchar), nil
+		}
+		return "bytea", nil
+	case "enum":
+		longest := 0
+		for _, v := range ct.Args {
+			if len(v) > longest {
+				longest = len(v)
+			}
+		}
+		if redshift {
+			return redshiftVarchar(longest), nil
+		}
+		return fmt.Sprintf("varchar(%d)", longest), nil
+	case "set":
+		length := len(ct.Args) - 1 // separating commas
+		for _, v := range ct.Args {
+			length += len(v)
+		}
+		if redshift {
+			return redshiftVarchar(length), nil
+		}
+		return fmt.Sprintf("varchar(%d)", length), nil
+	case "date":
+		return "date", nil
+	case "datetime":
+		return "timestamp", nil
+	case "timestamp":
+		return "timestamptz", nil
+	case "time":
+		// MySQL TIME is a duration from -838:59:59 to 838:59:59
+		if redshift {
+			return "varchar(10)", nil
+		}
+		return "interval", nil
+	case "year":
+		return "smallint", nil
+	case "json":
+		if redshift {
+			return fmt.Sprintf("varchar(%d)", RedshiftMaxVarchar), nil
+		}
+		return "jsonb", nil
+	case "geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		if redshift {
+			return "geometry", nil
+		}
+		return "text", nil
+	}
+	return "", fmt.Errorf("no %s mapping for MySQL type %s", dialect, f.Type)
+}
+
+// Double quote an identifier for Redshift and PostgreSQL
+func QuoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Numeric argument of the column type, 0 when missing
+func (ct ColumnType) arg(i int) int {
+	if i >= len(ct.Args) {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(ct.Args[i]))
+	return n
+}
+
+// Width of a BIT column, BIT alone is BIT(1)
+func (ct ColumnType) BitWidth() int {
+	if n := ct.arg(0); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Redshift varchar lengths are bytes while MySQL counts characters
+func redshiftVarchar(chars int) string {
+	length := chars * MysqlMaxCharBytes
+	if length > RedshiftMaxVarchar {
+		length = RedshiftMaxVarchar
+	}
+	if length == 0 {
+		length = 1
+	}
+	return fmt.Sprintf("varchar(%d)", length)
+}
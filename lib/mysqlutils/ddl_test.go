@@ -0,0 +1,79 @@
+package mysqlutils
+
+import (
+	"testing"
+)
+
+func TestWarehouseType(t *testing.T) {
+	tests := []struct {
+		mysql    string
+		redshift string
+		postgres string
+		sqlite   string
+	}{
+		{"tinyint(1)", "boolean", "boolean", "INTEGER"},
+		{"tinyint(4)", "smallint", "smallint", "INTEGER"},
+		{"smallint(5) unsigned", "integer", "integer", "INTEGER"},
+		{"int(11)", "integer", "integer", "INTEGER"},
+		{"int(10) unsigned", "bigint", "bigint", "INTEGER"},
+		{"bigint(20) unsigned", "numeric(20,0)", "numeric(20,0)", "INTEGER"},
+		{"decimal(10,2)", "numeric(10,2)", "numeric(10,2)", "NUMERIC"},
+		{"decimal", "numeric(10,0)", "numeric(10,0)", "NUMERIC"},
+		{"decimal(65,30)", "varchar(67)", "numeric(65,30)", "NUMERIC"},
+		{"float", "real", "real", "REAL"},
+		{"float(30)", "double precision", "double precision", "REAL"},
+		{"double", "double precision", "double precision", "REAL"},
+		{"bit(1)", "boolean", "boolean", "INTEGER"},
+		{"bit", "boolean", "boolean", "INTEGER"},
+		{"bit(12)", "bigint", "bigint", "INTEGER"},
+		{"char(3)", "varchar(12)", "char(3)", "TEXT"},
+		{"varchar(255)", "varchar(1020)", "varchar(255)", "TEXT"},
+		{"varchar(20000)", "varchar(65535)", "varchar(20000)", "TEXT"},
+		{"text", "varchar(65535)", "text", "TEXT"},
+		{"varbinary(16)", "varchar(65535)", "bytea", "BLOB"},
+		{"blob", "varchar(65535)", "bytea", "BLOB"},
+		{"enum('a','bcd')", "varchar(12)", "varchar(3)", "TEXT"},
+		{"date", "date", "date", "TEXT"},
+		{"datetime(3)", "timestamp", "timestamp", "TEXT"},
+		{"timestamp", "timestamptz", "timestamptz", "TEXT"},
+		{"time", "varchar(10)", "interval", "TEXT"},
+		{"year(4)", "smallint", "smallint", "INTEGER"},
+		{"json", "varchar(65535)", "jsonb", "TEXT"},
+		{"point", "geometry", "text", "TEXT"},
+		{"geometrycollection", "geometry", "text", "TEXT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mysql, func(t *testing.T) {
+			f := Field{Name: "c", Type: tt.mysql}
+			for _, want := range []struct{ dialect, colType string }{{Redshift, tt.redshift}, {Postgres, tt.postgres}, {Sqlite, tt.sqlite}} {
+				got, err := columnType(want.dialect, f)
+				if err != nil || got != want.colType {
+					t.Errorf("%s %s = %q, %v, want %q", want.dialect, tt.mysql, got, err, want.colType)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateTable(t *testing.T) {
+	schema := &Schema{Fields: []Field{
+		{Name: "id", Type: "int(10) unsigned", Null: "NO", Key: "PRI"},
+		{Name: `odd "name"`, Type: "varchar(10)", Null: "YES"},
+	}}
+	want := `CREATE TABLE "users" (
+  "id" bigint NOT NULL,
+  "odd ""name""" varchar(10),
+  PRIMARY KEY ("id")
+);
+`
+	if got, err := schema.DDL(Postgres, "users"); err != nil || got != want {
+		t.Errorf("DDL = %q, %v, want %q", got, err, want)
+	}
+	if _, err := schema.DDL("oracle", "users"); err == nil {
+		t.Error("unknown dialect did not fail")
+	}
+	unknown := &Schema{Fields: []Field{{Name: "c", Type: "vector(3)"}}}
+	if _, err := unknown.DDL(Redshift, "t"); err == nil {
+		t.Error("unmapped type did not fail")
+	}
+}
@@ -0,0 +1,91 @@
+package mysqlutils
+
+// Typed records carry dates, datetimes and timestamps in ISO-8601
+const EsDateTimeFormat = "strict_date_optional_time||epoch_millis"
+
+// Generate an Elasticsearch/OpenSearch mapping for the table
+// that follows the kinds its records are written with
+func (s *Schema) Mapping() map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i, f := range s.Fields {
+		properties[f.Name] = MappingType(s.Kind(i), f)
+	}
+	return map[string]interface{}{
+		"dynamic":    false,
+		"properties": properties,
+	}
+}
+
+// Map a MySQL column written as the given kind to an
+// Elasticsearch field mapping
+func MappingType(kind string, f Field) map[string]interface{} {
+	ct := f.ColumnType()
+	switch kind {
+	case KindBool:
+		return esType("boolean")
+	case KindInt, KindUint, KindYear:
+		switch ct.Base {
+		case "tinyint":
+			if ct.Unsigned {
+				return esType("short")
+			}
+			return esType("byte")
+		case "smallint", "year":
+			if ct.Unsigned {
+				return esType("integer")
+			}
+			return esType("short")
+		case "mediumint":
+			return esType("integer")
+		case "int", "integer":
+			if ct.Unsigned {
+				return esType("long")
+			}
+			return esType("integer")
+		}
+		return esType("long")
+	case KindBits, KindScaled:
+		return esType("long")
+	case KindFloat:
+		if ct.Base == "float" {
+			return esType("float")
+		}
+		return esType("double")
+	case KindDecimal:
+		return esType("double") // the source keeps the exact value
+	case KindDate, KindDatetime, KindTimestamp:
+		return map[string]interface{}{
+			"type":             "date",
+			"format":           EsDateTimeFormat,
+			"ignore_malformed": true,
+		}
+	case KindJson:
+		// any shape of document, kept in the source but not indexed
+		return map[string]interface{}{
+			"type":    "object",
+			"enabled": false,
+		}
+	case KindGeoJson:
+		return esType("geo_shape")
+	case KindWkt:
+		return esType("keyword") // geo_shape can't read the SRID prefix of EWKT
+	case KindTime, KindEnum, KindSet:
+		return esType("keyword")
+	case KindString:
+		switch ct.Base {
+		case "tinytext", "text", "mediumtext", "longtext", "json":
+			return esType("text")
+		}
+		return esType("keyword")
+	}
+	// binary values are kept in the source but not indexed
+	return map[string]interface{}{
+		"type":       "keyword",
+		"index":      false,
+		"doc_values": false,
+	}
+}
+
+func esType(t string) map[string]interface{} {
+	return map[string]interface{}{"type": t}
+}
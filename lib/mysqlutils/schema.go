@@ -1,7 +1,10 @@
 package mysqlutils
 
 import (
+	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/MasteryConnect/skrape/lib/setup"
 	"github.com/apex/log"
@@ -10,6 +13,12 @@ import (
 type Schema struct {
 	Fields   []Field `json:"fields"`
 	ColCount int     `json:"-"`
+	Table    string  `json:"-"`
+
+	// type mapping of typed records, see SetTypes
+	kinds       []string
+	columnTypes []ColumnType
+	location    *time.Location
 }
 
 type Paths struct {
@@ -20,17 +29,28 @@ type Field struct {
 	Name string `json:"name"`
 	Type string `json:"type"`
 	Null string `json:"null"`
+	Key  string `json:"key"`
+	Srid int    `json:"srid,omitempty"` // spatial columns declared with an SRID
+}
+
+// A MySQL COLUMN_TYPE broken into its parts,
+// e.g. "decimal(10,2) unsigned" becomes
+// {Base: "decimal", Args: ["10", "2"], Unsigned: true}
+type ColumnType struct {
+	Base     string
+	Args     []string
+	Unsigned bool
 }
 
 // Get the table schema
 func TableSchema(conn *setup.Connection, tableName string) (*Schema, *Paths) {
 	db := conn.Connect()
-	schema := Schema{Fields: []Field{}}
+	schema := Schema{Fields: []Field{}, Table: tableName}
 	paths := Paths{[]string{}}
 
 	defer db.Close()
 
-	query := fmt.Sprintf("select COLUMN_NAME as `Field`, COLUMN_TYPE as `Type`, IS_NULLABLE AS `Null` from information_schema.COLUMNS WHERE TABLE_NAME = '%s'", tableName)
+	query := fmt.Sprintf("select COLUMN_NAME as `Field`, COLUMN_TYPE as `Type`, IS_NULLABLE AS `Null`, COLUMN_KEY as `Key` from information_schema.COLUMNS WHERE TABLE_SCHEMA = '%s' AND TABLE_NAME = '%s' ORDER BY ORDINAL_POSITION", conn.Database, tableName)
 
 	rows, err := db.Query(query)
 	if err != nil {
@@ -38,12 +58,108 @@ func TableSchema(conn *setup.Connection, tableName string) (*Schema, *Paths) {
 	}
 	for rows.Next() {
 		var f Field
-		rows.Scan(&f.Name, &f.Type, &f.Null)
+		rows.Scan(&f.Name, &f.Type, &f.Null, &f.Key)
 		paths.JsonPaths = append(paths.JsonPaths, fmt.Sprintf("$['%s']", f.Name))
 		schema.Fields = append(schema.Fields, f)
 	}
 
 	schema.ColCount = len(schema.Fields)
+	if len(schema.SpatialColumns()) > 0 {
+		srids := columnSrids(db, conn.Database, tableName)
+		for i, f := range schema.Fields {
+			schema.Fields[i].Srid = srids[f.Name]
+		}
+	}
 
 	return &schema, &paths
 }
+
+// Names of the primary key columns in column order
+func (s *Schema) PrimaryKey() []string {
+	var keys []string
+	for _, f := range s.Fields {
+		if f.Key == "PRI" {
+			keys = append(keys, f.Name)
+		}
+	}
+	return keys
+}
+
+func (f Field) Nullable() bool {
+	return f.Null != "NO"
+}
+
+// Parse the COLUMN_TYPE of the field
+func (f Field) ColumnType() ColumnType {
+	orig := strings.TrimSpace(f.Type)
+	t := strings.ToLower(orig)
+	ct := ColumnType{}
+	if strings.HasSuffix(t, " zerofill") {
+		t = strings.TrimSuffix(t, " zerofill")
+	}
+	if strings.HasSuffix(t, " unsigned") {
+		ct.Unsigned = true
+		t = strings.TrimSuffix(t, " unsigned")
+	}
+	open := strings.Index(t, "(")
+	if open == -1 {
+		ct.Base = t
+		return ct
+	}
+	ct.Base = t[:open]
+	end := strings.LastIndex(t, ")")
+	if end < open {
+		end = len(t)
+	}
+	ct.Args = splitTypeArgs(orig[open+1 : end])
+	return ct
+}
+
+// Split the arguments of a column type on commas, keeping
+// quoted enum and set values intact and unquoting them
+func splitTypeArgs(args string) []string {
+	var (
+		values []string
+		cur    []byte
+		quoted bool
+	)
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case c == '\'' && quoted && i+1 < len(args) && args[i+1] == '\'':
+			cur = append(cur, '\'') // '' is an escaped quote
+			i++
+		case c == '\'':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			values = append(values, string(cur))
+			cur = cur[:0]
+		default:
+			cur = append(cur, c)
+		}
+	}
+	return append(values, string(cur))
+}
+
+// Get the CREATE TABLE statement the source server reports for a table
+func CreateStatement(conn *setup.Connection, tableName string) (string, error) {
+	db := conn.Connect()
+	defer db.Close()
+
+	var name, create string
+	err := db.QueryRow(fmt.Sprintf("SHOW CREATE TABLE `%s`", strings.Replace(tableName, "`", "``", -1))).Scan(&name, &create)
+	return create, err
+}
+
+// Size in bytes of a table's data as the source server estimates it
+func TableSize(conn *setup.Connection, tableName string) (int64, error) {
+	db := conn.Connect()
+	defer db.Close()
+
+	var size sql.NullInt64
+	err := db.QueryRow(
+		"SELECT DATA_LENGTH FROM information_schema.TABLES WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?",
+		conn.Database, tableName,
+	).Scan(&size)
+	return size.Int64, err
+}
@@ -0,0 +1,590 @@
+package mysqlutils
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+// GeoJSON names of the geometry types
+const (
+	GeoPoint              = "Point"
+	GeoLineString         = "LineString"
+	GeoPolygon            = "Polygon"
+	GeoMultiPoint         = "MultiPoint"
+	GeoMultiLineString    = "MultiLineString"
+	GeoMultiPolygon       = "MultiPolygon"
+	GeoGeometryCollection = "GeometryCollection"
+)
+
+// WKB type codes, in order
+var wkbTypes = []string{"", GeoPoint, GeoLineString, GeoPolygon, GeoMultiPoint, GeoMultiLineString, GeoMultiPolygon, GeoGeometryCollection}
+
+// Geometry is a spatial value, marshalling to GeoJSON. The
+// coordinates nest as GeoJSON does: []float64 for a point,
+// [][]float64 for a line string or multi point, [][][]float64
+// for a polygon or multi line string, [][][][]float64 for a
+// multi polygon.
+type Geometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates,omitempty"`
+	Geometries  []*Geometry `json:"geometries,omitempty"`
+}
+
+func isSpatial(base string) bool {
+	switch base {
+	case "geometry", "point", "linestring", "polygon", "multipoint",
+		"multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+		return true
+	}
+	return false
+}
+
+// Whether the column holds geometries
+func (f Field) IsSpatial() bool {
+	return isSpatial(f.ColumnType().Base)
+}
+
+// Positions of the spatial columns
+func (s *Schema) SpatialColumns() []int {
+	var cols []int
+	for i, f := range s.Fields {
+		if f.IsSpatial() {
+			cols = append(cols, i)
+		}
+	}
+	return cols
+}
+
+// Turn a spatial csv field as mysqldump wrote it, the escaped
+// SRID and WKB, into a quoted WKT field. A value with an SRID gets it as an EWKT prefix,
+// e.g. SRID=4326;POINT(1 2). NULL stays as it is.
+func SpatialFieldToWKT(field string) (string, error) {
+	if field == "NULL" {
+		return field, nil
+	}
+	if len(field) < 2 || field[0] != '"' || field[len(field)-1] != '"' {
+		return "", fmt.Errorf("spatial value %.20q is not a string", field)
+	}
+	text := strings.Replace(field[1:len(field)-1], `""`, `"`, -1)
+	data := []byte(utility.MysqlUnescape(text))
+	srid, g, err := ParseMysqlGeometry(data)
+	if err != nil {
+		return "", err
+	}
+	return `"` + EWKT(srid, g) + `"`, nil
+}
+
+// Well-known text of a geometry, prefixed with SRID=n; unless
+// the SRID is 0
+func EWKT(srid uint32, g *Geometry) string {
+	if srid == 0 {
+		return g.WKT()
+	}
+	return fmt.Sprintf("SRID=%d;%s", srid, g.WKT())
+}
+
+// SRID of each spatial column declared with one. Servers
+// before MySQL 8 have no SRID attribute and report none.
+func columnSrids(db *sql.DB, database, tableName string) map[string]int {
+	srids := map[string]int{}
+	rows, err := db.Query(
+		"SELECT COLUMN_NAME, SRS_ID FROM information_schema.ST_GEOMETRY_COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? AND SRS_ID IS NOT NULL",
+		database, tableName,
+	)
+	if err != nil {
+		return srids
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var srid int
+		if rows.Scan(&name, &srid) == nil {
+			srids[name] = srid
+		}
+	}
+	return srids
+}
+
+// Decode MySQL's internal geometry format: a little
+// endian SRID followed by the WKB of the geometry
+func ParseMysqlGeometry(data []byte) (uint32, *Geometry, error) {
+	if len(data) < 4 {
+		return 0, nil, fmt.Errorf("geometry of %d bytes is too short", len(data))
+	}
+	srid := binary.LittleEndian.Uint32(data)
+	g, err := ParseWKB(data[4:])
+	return srid, g, err
+}
+
+// Encode a geometry in MySQL's internal format
+func MysqlGeometry(srid uint32, g *Geometry) []byte {
+	data := make([]byte, 4, 64)
+	binary.LittleEndian.PutUint32(data, srid)
+	return appendWKB(data, g)
+}
+
+// Decode a 2D geometry in well-known binary
+func ParseWKB(data []byte) (*Geometry, error) {
+	r := &wkbReader{data: data}
+	g := r.geometry()
+	if r.err == nil && r.pos < len(data) {
+		r.err = fmt.Errorf("%d bytes left after the WKB geometry", len(data)-r.pos)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return g, nil
+}
+
+// The geometry in little endian well-known binary
+func (g *Geometry) WKB() []byte {
+	return appendWKB(nil, g)
+}
+
+type wkbReader struct {
+	data  []byte
+	pos   int
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *wkbReader) geometry() *Geometry {
+	if r.need(5) {
+		return nil
+	}
+	r.order = binary.ByteOrder(binary.LittleEndian)
+	if r.data[r.pos] == 0 {
+		r.order = binary.BigEndian
+	}
+	r.pos++
+	code := r.uint32()
+	if code == 0 || code >= uint32(len(wkbTypes)) {
+		r.err = fmt.Errorf("unsupported WKB geometry type %d", code)
+		return nil
+	}
+	g := &Geometry{Type: wkbTypes[code]}
+	switch g.Type {
+	case GeoPoint:
+		g.Coordinates = r.point()
+	case GeoLineString:
+		g.Coordinates = r.points()
+	case GeoPolygon:
+		g.Coordinates = r.rings()
+	default: // the multi types and collections hold whole geometries
+		n := r.uint32()
+		var parts []*Geometry
+		for i := uint32(0); i < n && r.err == nil; i++ {
+			parts = append(parts, r.geometry())
+		}
+		if r.err != nil {
+			return nil
+		}
+		g.Coordinates, g.Geometries = collect(g.Type, parts)
+	}
+	return g
+}
+
+// Coordinates of a multi geometry, or the members of a collection
+func collect(typ string, parts []*Geometry) (interface{}, []*Geometry) {
+	switch typ {
+	case GeoMultiPoint:
+		coords := [][]float64{}
+		for _, p := range parts {
+			c, _ := p.Coordinates.([]float64)
+			coords = append(coords, c)
+		}
+		return coords, nil
+	case GeoMultiLineString:
+		coords := [][][]float64{}
+		for _, p := range parts {
+			c, _ := p.Coordinates.([][]float64)
+			coords = append(coords, c)
+		}
+		return coords, nil
+	case GeoMultiPolygon:
+		coords := [][][][]float64{}
+		for _, p := range parts {
+			c, _ := p.Coordinates.([][][]float64)
+			coords = append(coords, c)
+		}
+		return coords, nil
+	}
+	if parts == nil {
+		parts = []*Geometry{}
+	}
+	return nil, parts
+}
+
+func (r *wkbReader) need(n int) bool {
+	if r.err == nil && r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("WKB ends after %d bytes", len(r.data))
+	}
+	return r.err != nil
+}
+
+func (r *wkbReader) uint32() uint32 {
+	if r.need(4) {
+		return 0
+	}
+	v := r.order.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *wkbReader) point() []float64 {
+	if r.need(16) {
+		return nil
+	}
+	x := math.Float64frombits(r.order.Uint64(r.data[r.pos:]))
+	y := math.Float64frombits(r.order.Uint64(r.data[r.pos+8:]))
+	r.pos += 16
+	if math.IsNaN(x) && math.IsNaN(y) {
+		return []float64{} // POINT EMPTY
+	}
+	return []float64{x, y}
+}
+
+func (r *wkbReader) points() [][]float64 {
+	n := r.uint32()
+	points := [][]float64{}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		points = append(points, r.point())
+	}
+	return points
+}
+
+func (r *wkbReader) rings() [][][]float64 {
+	n := r.uint32()
+	rings := [][][]float64{}
+	for i := uint32(0); i < n && r.err == nil; i++ {
+		rings = append(rings, r.points())
+	}
+	return rings
+}
+
+func appendWKB(b []byte, g *Geometry) []byte {
+	b = append(b, 1) // little endian
+	for code, t := range wkbTypes {
+		if t == g.Type {
+			b = appendUint32(b, uint32(code))
+		}
+	}
+	switch c := g.Coordinates.(type) {
+	case []float64:
+		return appendPoint(b, c)
+	case [][]float64:
+		if g.Type != GeoMultiPoint {
+			return appendPoints(b, c)
+		}
+		b = appendUint32(b, uint32(len(c)))
+		for _, p := range c {
+			b = appendWKB(b, &Geometry{Type: GeoPoint, Coordinates: p})
+		}
+		return b
+	case [][][]float64:
+		if g.Type != GeoMultiLineString {
+			return appendRings(b, c)
+		}
+		b = appendUint32(b, uint32(len(c)))
+		for _, l := range c {
+			b = appendWKB(b, &Geometry{Type: GeoLineString, Coordinates: l})
+		}
+		return b
+	case [][][][]float64:
+		b = appendUint32(b, uint32(len(c)))
+		for _, p := range c {
+			b = appendWKB(b, &Geometry{Type: GeoPolygon, Coordinates: p})
+		}
+		return b
+	}
+	b = appendUint32(b, uint32(len(g.Geometries)))
+	for _, m := range g.Geometries {
+		b = appendWKB(b, m)
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendPoint(b []byte, p []float64) []byte {
+	x, y := math.NaN(), math.NaN() // POINT EMPTY
+	if len(p) >= 2 {
+		x, y = p[0], p[1]
+	}
+	for _, v := range []float64{x, y} {
+		bits := math.Float64bits(v)
+		b = appendUint32(b, uint32(bits))
+		b = appendUint32(b, uint32(bits>>32))
+	}
+	return b
+}
+
+func appendPoints(b []byte, points [][]float64) []byte {
+	b = appendUint32(b, uint32(len(points)))
+	for _, p := range points {
+		b = appendPoint(b, p)
+	}
+	return b
+}
+
+func appendRings(b []byte, rings [][][]float64) []byte {
+	b = appendUint32(b, uint32(len(rings)))
+	for _, r := range rings {
+		b = appendPoints(b, r)
+	}
+	return b
+}
+
+// The geometry as MySQL writes it in well-known text
+func (g *Geometry) WKT() string {
+	name := strings.ToUpper(g.Type)
+	if g.isEmpty() {
+		return name + " EMPTY"
+	}
+	switch c := g.Coordinates.(type) {
+	case []float64:
+		return name + "(" + wktPoint(c) + ")"
+	case [][]float64:
+		if g.Type == GeoMultiPoint {
+			parts := make([]string, len(c))
+			for i, p := range c {
+				parts[i] = "(" + wktPoint(p) + ")"
+			}
+			return name + "(" + strings.Join(parts, ",") + ")"
+		}
+		return name + wktPoints(c)
+	case [][][]float64:
+		return name + wktRings(c)
+	case [][][][]float64:
+		parts := make([]string, len(c))
+		for i, p := range c {
+			parts[i] = wktRings(p)
+		}
+		return name + "(" + strings.Join(parts, ",") + ")"
+	}
+	parts := make([]string, len(g.Geometries))
+	for i, m := range g.Geometries {
+		parts[i] = m.WKT()
+	}
+	return name + "(" + strings.Join(parts, ",") + ")"
+}
+
+func (g *Geometry) isEmpty() bool {
+	switch c := g.Coordinates.(type) {
+	case []float64:
+		return len(c) == 0
+	case [][]float64:
+		return len(c) == 0
+	case [][][]float64:
+		return len(c) == 0
+	case [][][][]float64:
+		return len(c) == 0
+	}
+	return len(g.Geometries) == 0
+}
+
+func wktPoint(p []float64) string {
+	if len(p) < 2 {
+		return "EMPTY"
+	}
+	return strconv.FormatFloat(p[0], 'f', -1, 64) + " " + strconv.FormatFloat(p[1], 'f', -1, 64)
+}
+
+func wktPoints(points [][]float64) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = wktPoint(p)
+	}
+	return "(" + strings.Join(parts, ",") + ")"
+}
+
+func wktRings(rings [][][]float64) string {
+	parts := make([]string, len(rings))
+	for i, r := range rings {
+		parts[i] = wktPoints(r)
+	}
+	return "(" + strings.Join(parts, ",") + ")"
+}
+
+// Parse a 2D geometry in well-known text, e.g. POINT(1 2), or
+// in EWKT with an SRID=n; prefix
+func ParseEWKT(text string) (uint32, *Geometry, error) {
+	var srid uint64
+	if semi := strings.IndexByte(text, ';'); semi >= 0 && strings.HasPrefix(strings.ToUpper(text), "SRID=") {
+		var err error
+		if srid, err = strconv.ParseUint(strings.TrimSpace(text[5:semi]), 10, 32); err != nil {
+			return 0, nil, fmt.Errorf("EWKT %.40q: invalid SRID", text)
+		}
+		text = text[semi+1:]
+	}
+	g, err := ParseWKT(text)
+	return uint32(srid), g, err
+}
+
+// Parse a 2D geometry in well-known text, e.g. POINT(1 2)
+func ParseWKT(text string) (*Geometry, error) {
+	p := &wktParser{text: text}
+	g := p.geometry()
+	if p.err == nil && p.peek() != 0 {
+		p.fail("trailing text")
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return g, nil
+}
+
+type wktParser struct {
+	text string
+	pos  int
+	err  error
+}
+
+func (p *wktParser) fail(msg string) {
+	if p.err == nil {
+		p.err = fmt.Errorf("WKT %.40q: %s at %d", p.text, msg, p.pos)
+	}
+}
+
+// Next character after spaces, 0 at the end
+func (p *wktParser) peek() byte {
+	for p.pos < len(p.text) && p.text[p.pos] == ' ' {
+		p.pos++
+	}
+	if p.pos == len(p.text) {
+		return 0
+	}
+	return p.text[p.pos]
+}
+
+func (p *wktParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail(fmt.Sprintf("expected %q", c))
+		return
+	}
+	p.pos++
+}
+
+func (p *wktParser) word() string {
+	p.peek()
+	start := p.pos
+	for p.pos < len(p.text) && (p.text[p.pos] >= 'A' && p.text[p.pos] <= 'Z' || p.text[p.pos] >= 'a' && p.text[p.pos] <= 'z') {
+		p.pos++
+	}
+	return strings.ToUpper(p.text[start:p.pos])
+}
+
+// Parse a comma separated list in parentheses
+func (p *wktParser) list(item func()) {
+	p.expect('(')
+	for p.err == nil {
+		item()
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	p.expect(')')
+}
+
+func (p *wktParser) geometry() *Geometry {
+	name := p.word()
+	var g *Geometry
+	for _, t := range wkbTypes[1:] {
+		if strings.ToUpper(t) == name {
+			g = &Geometry{Type: t}
+		}
+	}
+	if name == "GEOMCOLLECTION" {
+		g = &Geometry{Type: GeoGeometryCollection}
+	}
+	if g == nil {
+		p.fail("unknown geometry type")
+		return nil
+	}
+	save := p.pos
+	if p.word() == "EMPTY" {
+		switch g.Type {
+		case GeoPoint:
+			g.Coordinates = []float64{}
+		case GeoLineString, GeoMultiPoint:
+			g.Coordinates = [][]float64{}
+		case GeoPolygon, GeoMultiLineString:
+			g.Coordinates = [][][]float64{}
+		case GeoMultiPolygon:
+			g.Coordinates = [][][][]float64{}
+		default:
+			g.Geometries = []*Geometry{}
+		}
+		return g
+	}
+	p.pos = save
+
+	switch g.Type {
+	case GeoPoint:
+		p.expect('(')
+		g.Coordinates = p.point()
+		p.expect(')')
+	case GeoLineString:
+		g.Coordinates = p.points()
+	case GeoPolygon, GeoMultiLineString:
+		g.Coordinates = p.rings()
+	case GeoMultiPoint:
+		coords := [][]float64{}
+		p.list(func() {
+			if p.peek() == '(' { // MULTIPOINT((1 2),(3 4)) as well as MULTIPOINT(1 2,3 4)
+				p.pos++
+				coords = append(coords, p.point())
+				p.expect(')')
+				return
+			}
+			coords = append(coords, p.point())
+		})
+		g.Coordinates = coords
+	case GeoMultiPolygon:
+		coords := [][][][]float64{}
+		p.list(func() { coords = append(coords, p.rings()) })
+		g.Coordinates = coords
+	default:
+		g.Geometries = []*Geometry{}
+		p.list(func() { g.Geometries = append(g.Geometries, p.geometry()) })
+	}
+	return g
+}
+
+func (p *wktParser) number() float64 {
+	p.peek()
+	start := p.pos
+	for p.pos < len(p.text) && strings.IndexByte("+-.0123456789eE", p.text[p.pos]) >= 0 {
+		p.pos++
+	}
+	v, err := strconv.ParseFloat(p.text[start:p.pos], 64)
+	if err != nil {
+		p.fail("expected a number")
+	}
+	return v
+}
+
+func (p *wktParser) point() []float64 {
+	return []float64{p.number(), p.number()}
+}
+
+func (p *wktParser) points() [][]float64 {
+	points := [][]float64{}
+	p.list(func() { points = append(points, p.point()) })
+	return points
+}
+
+func (p *wktParser) rings() [][][]float64 {
+	rings := [][][]float64{}
+	p.list(func() { rings = append(rings, p.points()) })
+	return rings
+}
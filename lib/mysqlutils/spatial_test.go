@@ -0,0 +1,145 @@
+package mysqlutils
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+// Escapes mysqldump writes binary strings with
+var dumpEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\x00", `\0`,
+	"'", `\'`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+var wktGeometries = []string{
+	"POINT(1 2)",
+	"POINT(-71.0589 42.3601)",
+	"POINT EMPTY",
+	"LINESTRING(0 0,1 1,2 0.5)",
+	"LINESTRING EMPTY",
+	"POLYGON((0 0,4 0,4 4,0 4,0 0),(1 1,2 1,2 2,1 1))",
+	"POLYGON EMPTY",
+	"MULTIPOINT((0 0),(1.5 -2))",
+	"MULTIPOINT EMPTY",
+	"MULTILINESTRING((0 0,1 1),(2 2,3 3,4 4))",
+	"MULTILINESTRING EMPTY",
+	"MULTIPOLYGON(((0 0,1 0,1 1,0 0)),((2 2,3 2,3 3,2 2),(2.2 2.1,2.8 2.1,2.8 2.6,2.2 2.1)))",
+	"MULTIPOLYGON EMPTY",
+	"GEOMETRYCOLLECTION(POINT(1 2),LINESTRING(0 0,1 1),POLYGON((0 0,1 0,1 1,0 0)))",
+	"GEOMETRYCOLLECTION(MULTIPOINT((1 2)),GEOMETRYCOLLECTION(POINT(3 4)),GEOMETRYCOLLECTION EMPTY)",
+	"GEOMETRYCOLLECTION EMPTY",
+}
+
+func TestGeometryRoundTrip(t *testing.T) {
+	for _, wkt := range wktGeometries {
+		g, err := ParseWKT(wkt)
+		if err != nil {
+			t.Errorf("ParseWKT(%q): %v", wkt, err)
+			continue
+		}
+		if got := g.WKT(); got != wkt {
+			t.Errorf("ParseWKT(%q).WKT() = %q", wkt, got)
+		}
+		decoded, err := ParseWKB(g.WKB())
+		if err != nil {
+			t.Errorf("ParseWKB of %q: %v", wkt, err)
+			continue
+		}
+		if got := decoded.WKT(); got != wkt {
+			t.Errorf("WKB round trip of %q = %q", wkt, got)
+		}
+	}
+}
+
+func TestSpatialFieldToWKT(t *testing.T) {
+	for _, srid := range []uint32{0, 4326} {
+		for _, wkt := range wktGeometries {
+			g, err := ParseWKT(wkt)
+			if err != nil {
+				t.Fatalf("ParseWKT(%q): %v", wkt, err)
+			}
+			want := `"` + EWKT(srid, g) + `"`
+			data := MysqlGeometry(srid, g)
+			field := utility.MysqlInsertValuesToFields("'" + dumpEscaper.Replace(string(data)) + "'")[0]
+			got, err := SpatialFieldToWKT(field)
+			if err != nil || got != want {
+				t.Errorf("SpatialFieldToWKT(%q) = %q, %v, want %q", field, got, err, want)
+			}
+
+			gotSrid, parsed, err := ParseEWKT(want[1 : len(want)-1])
+			if err != nil || gotSrid != srid || parsed.WKT() != wkt {
+				t.Errorf("ParseEWKT(%s) = %d, %v, %v", want, gotSrid, parsed, err)
+			}
+		}
+	}
+	if got, err := SpatialFieldToWKT("NULL"); err != nil || got != "NULL" {
+		t.Errorf("SpatialFieldToWKT(NULL) = %q, %v", got, err)
+	}
+}
+
+func TestParseMysqlGeometryTruncated(t *testing.T) {
+	for _, wkt := range wktGeometries {
+		g, _ := ParseWKT(wkt)
+		data := MysqlGeometry(4326, g)
+		for n := 0; n < len(data); n++ {
+			if _, _, err := ParseMysqlGeometry(data[:n]); err == nil {
+				t.Errorf("%s cut to %d of %d bytes parsed without an error", wkt, n, len(data))
+			}
+		}
+		if _, _, err := ParseMysqlGeometry(append(data, 0)); err == nil {
+			t.Errorf("%s with a trailing byte parsed without an error", wkt)
+		}
+	}
+}
+
+func TestParseMysqlGeometryGarbage(t *testing.T) {
+	bad := [][]byte{
+		nil,
+		{0, 0, 0, 0},
+		{0, 0, 0, 0, 1, 0, 0, 0, 0},  // type 0
+		{0, 0, 0, 0, 1, 99, 0, 0, 0}, // unknown type
+		{0, 0, 0, 0, 1, 2, 0, 0, 0, 255, 255, 255, 255},  // line string of 2^32-1 points
+		{0, 0, 0, 0, 1, 7, 0, 0, 0, 255, 255, 255, 255},  // collection of 2^32-1 geometries
+		{0, 0, 0, 0, 0, 0, 0, 0, 4, 0, 0, 0, 2, 0, 0, 0}, // big endian multi point, members missing
+		[]byte("POINT(1 2)"),
+	}
+	for _, data := range bad {
+		if _, _, err := ParseMysqlGeometry(data); err == nil {
+			t.Errorf("ParseMysqlGeometry(%x) parsed without an error", data)
+		}
+	}
+
+	// random bytes may happen to decode, they must never panic
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		data := make([]byte, rnd.Intn(64))
+		rnd.Read(data)
+		if len(data) > 5 {
+			data[5] = byte(rnd.Intn(9)) // mostly known types
+		}
+		ParseMysqlGeometry(data)
+	}
+
+	if _, err := SpatialFieldToWKT(`"zz"`); err == nil {
+		t.Error(`SpatialFieldToWKT("zz") parsed without an error`)
+	}
+	if _, err := SpatialFieldToWKT("12"); err == nil {
+		t.Error("SpatialFieldToWKT(12) parsed without an error")
+	}
+}
+
+func TestParseEWKTErrors(t *testing.T) {
+	for _, text := range []string{"SRID=x;POINT(1 2)", "SRID=4326;", "POINT(1)", "POINT(1 2", "CIRCLE(1 2)", "POINT(1 2) x"} {
+		if _, _, err := ParseEWKT(text); err == nil {
+			t.Errorf("ParseEWKT(%q) parsed without an error", text)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package mysqlutils
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+// Kinds of value a column is written as in typed records
+const (
+	KindString    = "string"    // unescaped text
+	KindRaw       = "raw"       // text as mysqldump wrote it
+	KindInt       = "int"       // signed 64 bit integer
+	KindUint      = "uint"      // unsigned 64 bit integer
+	KindFloat     = "float"     // 64 bit float
+	KindDecimal   = "decimal"   // exact decimal as a string
+	KindScaled    = "scaled"    // decimal as an integer count of its smallest unit
+	KindBool      = "bool"      // true unless 0
+	KindBits      = "bits"      // BIT value as an unsigned integer
+	KindDate      = "date"      // YYYY-MM-DD
+	KindDatetime  = "datetime"  // ISO-8601 in the source time zone
+	KindTimestamp = "timestamp" // ISO-8601 in UTC
+	KindYear      = "year"      // integer year
+	KindTime      = "time"      // [-]HH:MM:SS[.ffffff], hours can pass 24
+	KindEnum      = "enum"      // the value's label
+	KindSet       = "set"       // array of the labels in the set
+	KindJson      = "json"      // nested JSON value
+	KindGeoJson   = "geojson"   // GeoJSON geometry object, without the SRID
+	KindWkt       = "wkt"       // well-known text of a geometry, SRID=n; first when it has one
+)
+
+var Kinds = []string{
+	KindString, KindRaw, KindInt, KindUint, KindFloat, KindDecimal, KindScaled, KindBool,
+	KindBits, KindDate, KindDatetime, KindTimestamp, KindYear, KindTime, KindEnum, KindSet, KindJson,
+	KindGeoJson, KindWkt,
+}
+
+// Kinds decimal columns can be written as
+var DecimalKinds = []string{KindDecimal, KindScaled, KindFloat}
+
+// Kinds JSON columns can be written as
+var JsonKinds = []string{KindJson, KindString}
+
+var (
+	decimalText = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]+$`)
+	timeText    = regexp.MustCompile(`^-?[0-9]{1,3}:[0-5][0-9]:[0-5][0-9](\.[0-9]{1,6})?$`)
+)
+
+// TypeMap decides the kind of every column. Columns are
+// matched by "table.column" first, then by "column".
+type TypeMap struct {
+	Decimal  string            // kind of decimal columns
+	Json     string            // kind of JSON columns
+	Location *time.Location    // zone DATETIME values are in
+	Columns  map[string]string // kind of single columns
+}
+
+// The mapping used when none is configured
+func DefaultTypeMap() *TypeMap {
+	return &TypeMap{Decimal: KindDecimal, Json: KindJson, Location: time.UTC}
+}
+
+// Kind of a column of the table
+func (tm *TypeMap) Kind(table string, f Field) string {
+	if kind, ok := tm.Columns[table+"."+f.Name]; ok {
+		return kind
+	}
+	if kind, ok := tm.Columns[f.Name]; ok {
+		return kind
+	}
+	return f.Kind(tm.Decimal, tm.Json)
+}
+
+// Kind a column is written as by default, given the kinds
+// of decimal and JSON columns
+func (f Field) Kind(decimal, json string) string {
+	ct := f.ColumnType()
+	switch ct.Base {
+	case "bool", "boolean":
+		return KindBool
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		if ct.Base == "tinyint" && ct.arg(0) == 1 {
+			return KindBool
+		}
+		if ct.Unsigned {
+			return KindUint
+		}
+		return KindInt
+	case "float", "double", "real":
+		return KindFloat
+	case "decimal", "numeric", "fixed", "dec":
+		return decimal
+	case "bit":
+		if ct.arg(0) <= 1 {
+			return KindBool
+		}
+		return KindBits
+	case "date":
+		return KindDate
+	case "datetime":
+		return KindDatetime
+	case "timestamp":
+		return KindTimestamp
+	case "year":
+		return KindYear
+	case "time":
+		return KindTime
+	case "enum":
+		return KindEnum
+	case "set":
+		return KindSet
+	case "json":
+		return json
+	}
+	if isSpatial(ct.Base) {
+		return KindGeoJson
+	}
+	switch ct.Base {
+	case "char", "varchar", "tinytext", "text", "mediumtext", "longtext":
+		return KindString
+	}
+	return KindRaw
+}
+
+// Set the type mapping records of the table are built with
+func (s *Schema) SetTypes(tm *TypeMap) {
+	s.kinds = make([]string, len(s.Fields))
+	s.columnTypes = make([]ColumnType, len(s.Fields))
+	for i, f := range s.Fields {
+		s.kinds[i] = tm.Kind(s.Table, f)
+		s.columnTypes[i] = f.ColumnType()
+	}
+	s.location = tm.Location
+}
+
+// Kind the i-th column is written as
+func (s *Schema) Kind(i int) string {
+	if s.kinds == nil {
+		s.SetTypes(DefaultTypeMap())
+	}
+	return s.kinds[i]
+}
+
+// Convert the text mysqldump wrote for the i-th column into
+// the value of its kind. NULL and zero dates become nil.
+func (s *Schema) Value(i int, raw string) (interface{}, error) {
+	kind := s.Kind(i)
+	if raw == "NULL" {
+		return nil, nil
+	}
+	ct := s.columnTypes[i]
+	switch kind {
+	case KindRaw:
+		return raw, nil
+	case KindInt:
+		return strconv.ParseInt(raw, 10, 64)
+	case KindUint:
+		return strconv.ParseUint(raw, 10, 64)
+	case KindFloat:
+		return strconv.ParseFloat(raw, 64)
+	case KindDecimal:
+		if !decimalText.MatchString(raw) {
+			return nil, fmt.Errorf("%q is not a decimal", raw)
+		}
+		return raw, nil
+	case KindScaled:
+		return scaleDecimal(raw, ct.arg(1))
+	case KindBool:
+		n, err := ParseBits(raw)
+		return n != 0, err
+	case KindBits:
+		return ParseBits(raw)
+	case KindDate:
+		if isZeroDate(raw) {
+			return nil, nil
+		}
+		t, err := time.Parse("2006-01-02", raw)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format("2006-01-02"), nil
+	case KindDatetime, KindTimestamp:
+		if isZeroDate(raw) {
+			return nil, nil
+		}
+		loc := time.UTC // mysqldump writes timestamps in UTC unless --skip-tz-utc
+		if kind == KindDatetime {
+			loc = s.location
+		}
+		// fractional seconds are accepted after the seconds
+		t, err := time.ParseInLocation("2006-01-02 15:04:05", raw, loc)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case KindYear:
+		year, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || year == 0 {
+			return nil, err
+		}
+		return year, nil
+	case KindTime:
+		if !timeText.MatchString(raw) {
+			return nil, fmt.Errorf("%q is not a time", raw)
+		}
+		return raw, nil
+	case KindSet:
+		if raw == "" {
+			return []string{}, nil
+		}
+		return strings.Split(utility.MysqlUnescape(raw), ","), nil
+	case KindGeoJson:
+		_, g, err := ParseEWKT(raw) // spatial values reach the sinks as EWKT
+		if err != nil {
+			return nil, err
+		}
+		return g, nil
+	case KindWkt:
+		return raw, nil
+	case KindJson:
+		doc := json.RawMessage(utility.MysqlUnescape(raw))
+		if !json.Valid(doc) {
+			return nil, fmt.Errorf("%q is not valid JSON", raw)
+		}
+		return doc, nil
+	}
+	return utility.MysqlUnescape(raw), nil // string and enum
+}
+
+// A decimal as an integer of 10^-scale units, e.g.
+// "12.34" in a decimal(10,2) column becomes 1234
+func scaleDecimal(raw string, scale int) (int64, error) {
+	if !decimalText.MatchString(raw) {
+		return 0, fmt.Errorf("%q is not a decimal", raw)
+	}
+	digits := strings.TrimLeft(raw, "+-")
+	whole, frac := digits, ""
+	if dot := strings.IndexByte(digits, '.'); dot >= 0 {
+		whole, frac = digits[:dot], digits[dot+1:]
+	}
+	if len(frac) > scale {
+		return 0, fmt.Errorf("%q has more than %d decimals", raw, scale)
+	}
+	frac += strings.Repeat("0", scale-len(frac))
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(raw, "-") {
+		n = -n
+	}
+	return n, nil
+}
+
+// Parse a BIT value in the forms mysqldump writes: b'0101',
+// or a plain number for boolean integer columns
+func ParseBits(raw string) (uint64, error) {
+	switch {
+	case strings.HasPrefix(raw, "b'"), strings.HasPrefix(raw, "B'"):
+		return strconv.ParseUint(strings.TrimRight(raw[2:], `'"`), 2, 64)
+	case strings.HasPrefix(raw, "-"):
+		n, err := strconv.ParseInt(raw, 10, 64) // a signed tinyint read as a bool
+		return uint64(n), err
+	}
+	return strconv.ParseUint(raw, 10, 64)
+}
+
+// MySQL writes invalid dates as zeros, they have no ISO form
+func isZeroDate(raw string) bool {
+	return strings.HasPrefix(raw, "0000-00-00")
+}
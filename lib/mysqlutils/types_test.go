@@ -0,0 +1,109 @@
+package mysqlutils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestValue(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	tests := []struct {
+		name    string
+		colType string
+		raw     string
+		want    interface{}
+	}{
+		{"null", "int(11)", "NULL", nil},
+		{"int", "int(11)", "-42", int64(-42)},
+		{"uint", "bigint(20) unsigned", "18446744073709551615", uint64(18446744073709551615)},
+		{"float", "double", "1.5", 1.5},
+		{"decimal", "decimal(10,2)", "-12.30", "-12.30"},
+		{"tinyint bool", "tinyint(1)", "1", true},
+		{"tinyint bool false", "tinyint(1)", "0", false},
+		{"signed tinyint bool", "tinyint(1)", "-1", true},
+		{"bit(1) set", "bit(1)", "b'1'", true},
+		{"bit(1) clear", "bit(1)", "b'0'", false},
+		{"bit", "bit", "b'1'", true},
+		{"bit(12)", "bit(12)", "b'101000000101'", uint64(2565)},
+		{"bit(64)", "bit(64)", "b'1111111111111111111111111111111111111111111111111111111111111111'", uint64(18446744073709551615)},
+		{"date", "date", "2024-02-29", "2024-02-29"},
+		{"zero date", "date", "0000-00-00", nil},
+		{"zero datetime", "datetime", "0000-00-00 00:00:00", nil},
+		{"zero timestamp", "timestamp", "0000-00-00 00:00:00", nil},
+		{"datetime in the source zone", "datetime", "2024-07-01 12:00:00", "2024-07-01T12:00:00-04:00"},
+		{"datetime fraction", "datetime(6)", "2024-01-01 12:00:00.250000", "2024-01-01T12:00:00.25-05:00"},
+		{"timestamp in UTC", "timestamp", "2024-07-01 12:00:00", "2024-07-01T12:00:00Z"},
+		{"timestamp fraction", "timestamp(3)", "2024-07-01 12:00:00.125", "2024-07-01T12:00:00.125Z"},
+		{"year", "year(4)", "2024", int64(2024)},
+		{"zero year", "year(4)", "0000", nil},
+		{"time past a day", "time", "-838:59:59", "-838:59:59"},
+		{"set", "set('a','b')", "a,b", []string{"a", "b"}},
+		{"empty set", "set('a','b')", "", []string{}},
+		{"json", "json", `{\"a\":1}`, json.RawMessage(`{"a":1}`)},
+		{"string unescaped", "varchar(10)", `it\'s`, "it's"},
+		{"blob raw", "blob", `a\0b`, `a\0b`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Schema{Fields: []Field{{Name: "c", Type: tt.colType}}}
+			s.SetTypes(&TypeMap{Decimal: KindDecimal, Json: KindJson, Location: newYork})
+			got, err := s.Value(0, tt.raw)
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Value(%s, %q) = %#v, %v, want %#v", tt.colType, tt.raw, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueErrors(t *testing.T) {
+	tests := []struct {
+		colType string
+		raw     string
+	}{
+		{"int(11)", "abc"},
+		{"int(10) unsigned", "-1"},
+		{"decimal(10,2)", "1e5"},
+		{"bit(8)", "b'102'"},
+		{"bit(8)", "'x'"},
+		{"date", "2024-02-30"},
+		{"datetime", "yesterday"},
+		{"time", "12:60:00"},
+		{"json", "{"},
+	}
+	for _, tt := range tests {
+		s := &Schema{Fields: []Field{{Name: "c", Type: tt.colType}}}
+		if got, err := s.Value(0, tt.raw); err == nil {
+			t.Errorf("Value(%s, %q) = %#v without an error", tt.colType, tt.raw, got)
+		}
+	}
+}
+
+func TestScaledValue(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want int64
+	}{
+		{"12.34", 1234},
+		{"-0.5", -50},
+		{"7", 700},
+		{".01", 1},
+	}
+	for _, tt := range tests {
+		s := &Schema{Fields: []Field{{Name: "c", Type: "decimal(10,2)"}}}
+		s.SetTypes(&TypeMap{Decimal: KindScaled, Json: KindJson, Location: time.UTC})
+		got, err := s.Value(0, tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("scaled %q = %v, %v, want %d", tt.raw, got, err, tt.want)
+		}
+	}
+	s := &Schema{Fields: []Field{{Name: "c", Type: "decimal(10,2)"}}}
+	s.SetTypes(&TypeMap{Decimal: KindScaled, Json: KindJson, Location: time.UTC})
+	if _, err := s.Value(0, "1.234"); err == nil {
+		t.Error("a decimal with more digits than its scale did not fail")
+	}
+}
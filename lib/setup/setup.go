@@ -112,10 +112,10 @@ func (c *Connection) Setup() []string {
 func MysqlDefaults(req bool) string {
 	var pass string
 	if req != true {
-		fmt.Print("Enter Password: ")
+		fmt.Fprint(os.Stderr, "Enter Password: ")
 		bytePwd, err := terminal.ReadPassword(syscall.Stdin)
 		pass = string(bytePwd)
-		fmt.Println("")
+		fmt.Fprintln(os.Stderr, "")
 		if err != nil {
 			log.Fatal(err.Error())
 		}
@@ -0,0 +1,132 @@
+package sink
+
+import (
+	"crypto/md5"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+const (
+	KinesisMaxRecordBytes = 1024 * 1024 // data plus partition key of one record
+	KinesisMaxPutBytes    = 5 * 1024 * 1024
+	KinesisMaxPutRecords  = 500
+)
+
+// Header the KPL puts in front of an aggregated record
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// Protobuf field numbers and wire types of the KPL messages:
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table     = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records                 = 3;
+//	}
+//	message Record {
+//	  required uint64 partition_key_index     = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes  data                    = 3;
+//	}
+const (
+	pbVarint = 0
+	pbBytes  = 2
+
+	aggPartitionKeyTable = 1
+	aggRecords           = 3
+	recPartitionKeyIndex = 1
+	recData              = 3
+)
+
+// aggregator packs rows into a KPL aggregated record:
+// the magic header, a protobuf AggregatedRecord and
+// the MD5 of the protobuf, which the KCL and the
+// deaggregation libraries unpack into the original rows.
+type aggregator struct {
+	keys    []string
+	keyIdx  map[string]int
+	message []byte // protobuf encoded so far
+	count   int
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{keyIdx: map[string]int{}}
+}
+
+// Whether a row still fits in the record
+func (a *aggregator) fits(key string, data []byte) bool {
+	if a.count == 0 {
+		return true
+	}
+	grow := len(a.recordField(key, data))
+	if _, ok := a.keyIdx[key]; !ok {
+		grow += fieldLen(len(key))
+	}
+	first := a.keys[0] // the partition key of the whole record
+	return len(kplMagic)+len(a.message)+grow+md5.Size+len(first) <= KinesisMaxRecordBytes
+}
+
+func (a *aggregator) add(key string, data []byte) {
+	if _, ok := a.keyIdx[key]; !ok {
+		a.keyIdx[key] = len(a.keys)
+		a.keys = append(a.keys, key)
+		a.message = appendBytesField(a.message, aggPartitionKeyTable, []byte(key))
+	}
+	a.message = append(a.message, a.recordField(key, data)...)
+	a.count++
+}
+
+func (a *aggregator) empty() bool {
+	return a.count == 0
+}
+
+// The aggregated record as a put entry, the aggregator starts over
+func (a *aggregator) entry() *kinesis.PutRecordsRequestEntry {
+	sum := md5.Sum(a.message)
+	data := make([]byte, 0, len(kplMagic)+len(a.message)+md5.Size)
+	data = append(data, kplMagic...)
+	data = append(data, a.message...)
+	data = append(data, sum[:]...)
+
+	entry := &kinesis.PutRecordsRequestEntry{
+		Data:         data,
+		PartitionKey: aws.String(a.keys[0]),
+	}
+	a.keys = nil
+	a.keyIdx = map[string]int{}
+	a.message = nil
+	a.count = 0
+	return entry
+}
+
+// Encode a Record message as a field of the AggregatedRecord
+func (a *aggregator) recordField(key string, data []byte) []byte {
+	idx, ok := a.keyIdx[key]
+	if !ok {
+		idx = len(a.keys)
+	}
+	var rec []byte
+	rec = appendVarint(rec, recPartitionKeyIndex<<3|pbVarint)
+	rec = appendVarint(rec, uint64(idx))
+	rec = appendBytesField(rec, recData, data)
+	return appendBytesField(nil, aggRecords, rec)
+}
+
+func appendBytesField(buf []byte, field int, value []byte) []byte {
+	buf = appendVarint(buf, uint64(field<<3|pbBytes))
+	buf = appendVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Encoded size of a length delimited field with a one byte tag
+func fieldLen(n int) int {
+	return 1 + len(appendVarint(nil, uint64(n))) + n
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
@@ -0,0 +1,158 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type kplRow struct {
+	key  string
+	data string
+}
+
+// Unpack an aggregated record the way the deaggregation libraries do
+func deaggregate(t *testing.T, data []byte) []kplRow {
+	t.Helper()
+	if !bytes.HasPrefix(data, kplMagic) {
+		t.Fatalf("record starts with %x, not the KPL magic", data[:4])
+	}
+	message := data[len(kplMagic) : len(data)-md5.Size]
+	if sum := md5.Sum(message); !bytes.Equal(sum[:], data[len(data)-md5.Size:]) {
+		t.Fatalf("MD5 %x does not match the message", data[len(data)-md5.Size:])
+	}
+
+	var keys []string
+	var rows []kplRow
+	for _, f := range readFields(t, message) {
+		switch f.number {
+		case aggPartitionKeyTable:
+			keys = append(keys, string(f.bytes))
+		case aggRecords:
+			var row kplRow
+			for _, rf := range readFields(t, f.bytes) {
+				switch rf.number {
+				case recPartitionKeyIndex:
+					if int(rf.varint) >= len(keys) {
+						t.Fatalf("key index %d of %d keys", rf.varint, len(keys))
+					}
+					row.key = keys[rf.varint]
+				case recData:
+					row.data = string(rf.bytes)
+				}
+			}
+			rows = append(rows, row)
+		default:
+			t.Fatalf("unexpected field %d", f.number)
+		}
+	}
+	return rows
+}
+
+type pbField struct {
+	number int
+	varint uint64
+	bytes  []byte
+}
+
+func readFields(t *testing.T, buf []byte) []pbField {
+	t.Helper()
+	var fields []pbField
+	for len(buf) > 0 {
+		tag, n := readVarint(t, buf)
+		buf = buf[n:]
+		f := pbField{number: int(tag >> 3)}
+		switch tag & 7 {
+		case pbVarint:
+			f.varint, n = readVarint(t, buf)
+			buf = buf[n:]
+		case pbBytes:
+			size, n := readVarint(t, buf)
+			buf = buf[n:]
+			if uint64(len(buf)) < size {
+				t.Fatalf("field %d of %d bytes, %d left", f.number, size, len(buf))
+			}
+			f.bytes, buf = buf[:size], buf[size:]
+		default:
+			t.Fatalf("unexpected wire type %d", tag&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func readVarint(t *testing.T, buf []byte) (uint64, int) {
+	t.Helper()
+	var v uint64
+	for i, b := range buf {
+		v |= uint64(b&0x7f) << (7 * uint(i))
+		if b < 0x80 {
+			return v, i + 1
+		}
+	}
+	t.Fatal("truncated varint")
+	return 0, 0
+}
+
+func TestAggregator(t *testing.T) {
+	tests := []struct {
+		name string
+		rows []kplRow
+	}{
+		{"one row", []kplRow{{"1", `{"id":1}`}}},
+		{"shared key", []kplRow{{"a", "x"}, {"a", "y"}, {"a", "z"}}},
+		{"several keys", []kplRow{{"a", "x"}, {"b", "y"}, {"a", "z"}, {"c", ""}}},
+		{"long data", []kplRow{{"k", string(bytes.Repeat([]byte("d"), 300))}, {"k", "short"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAggregator()
+			for _, r := range tt.rows {
+				if !a.fits(r.key, []byte(r.data)) {
+					t.Fatalf("row %q does not fit", r.data)
+				}
+				a.add(r.key, []byte(r.data))
+			}
+			entry := a.entry()
+			if got := aws.StringValue(entry.PartitionKey); got != tt.rows[0].key {
+				t.Errorf("partition key = %q, want %q", got, tt.rows[0].key)
+			}
+			if got := deaggregate(t, entry.Data); !reflect.DeepEqual(got, tt.rows) {
+				t.Errorf("deaggregated %q, want %q", got, tt.rows)
+			}
+			if !a.empty() {
+				t.Error("aggregator not empty after entry()")
+			}
+		})
+	}
+}
+
+func TestAggregatorFits(t *testing.T) {
+	a := newAggregator()
+	data := bytes.Repeat([]byte("x"), 1000)
+	var added int
+	for i := 0; a.fits(fmt.Sprint(i), data); i++ {
+		a.add(fmt.Sprint(i), data)
+		added++
+	}
+	entry := a.entry()
+	size := len(entry.Data) + len(aws.StringValue(entry.PartitionKey))
+	if size > KinesisMaxRecordBytes {
+		t.Errorf("record of %d bytes is over the limit of %d", size, KinesisMaxRecordBytes)
+	}
+	if size+1000+20 <= KinesisMaxRecordBytes {
+		t.Errorf("record of %d bytes stopped with room for another row", size)
+	}
+	if got := len(deaggregate(t, entry.Data)); got != added {
+		t.Errorf("deaggregated %d rows, want %d", got, added)
+	}
+
+	// a single row always fits, the sink deals with oversize ones
+	if !newAggregator().fits("k", make([]byte, KinesisMaxRecordBytes)) {
+		t.Error("an empty aggregator refused a row")
+	}
+}
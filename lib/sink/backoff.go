@@ -0,0 +1,29 @@
+package sink
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff hands out exponentially growing pauses with
+// full jitter, a random pause between zero and the cap
+// of the attempt, so throttled writers spread out
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt uint
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+// Pause before the next attempt
+func (b *backoff) next() time.Duration {
+	ceiling := b.max
+	if b.attempt < 32 && b.base<<b.attempt < b.max {
+		ceiling = b.base << b.attempt
+	}
+	b.attempt++
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
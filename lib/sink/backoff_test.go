@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     time.Duration
+		max      time.Duration
+		ceilings []time.Duration
+	}{
+		{"doubling", 100 * time.Millisecond, 5 * time.Second,
+			[]time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond, 3200 * time.Millisecond, 5 * time.Second, 5 * time.Second}},
+		{"base over max", 10 * time.Second, time.Second,
+			[]time.Duration{time.Second, time.Second}},
+		{"equal", time.Second, time.Second,
+			[]time.Duration{time.Second, time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.base, tt.max)
+			for i, ceiling := range tt.ceilings {
+				if got := b.next(); got < 0 || got > ceiling {
+					t.Errorf("attempt %d: pause %s outside 0..%s", i, got, ceiling)
+				}
+			}
+		})
+	}
+}
+
+// Shifting the base past 64 bits must not wrap the pause around
+func TestBackoffManyAttempts(t *testing.T) {
+	b := newBackoff(time.Millisecond, time.Minute)
+	for i := 0; i < 100; i++ {
+		if got := b.next(); got < 0 || got > time.Minute {
+			t.Fatalf("attempt %d: pause %s outside 0..%s", i, got, time.Minute)
+		}
+	}
+}
+
+// The pauses are jittered, not fixed at the ceiling
+func TestBackoffJitter(t *testing.T) {
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 20; i++ {
+		b := newBackoff(time.Second, time.Second)
+		seen[b.next()] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("20 pauses were all %v", seen)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	tests := []struct {
+		name    string
+		rate    float64
+		tokens  float64
+		idle    time.Duration
+		take    int
+		want    float64 // tokens left
+		minWait time.Duration
+	}{
+		{"full bucket", 1000, 1000, 0, 400, 600, 0},
+		{"refilled while idle", 1000, 0, 300 * time.Millisecond, 100, 200, 0},
+		{"refill capped at one second", 1000, 0, 10 * time.Second, 1000, 0, 0},
+		{"debt waited off", 1000, 0, 0, 50, -50, 50 * time.Millisecond},
+		{"take over the bucket", 100, 100, 0, 105, -5, 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRateLimiter(tt.rate)
+			r.tokens = tt.tokens
+			r.last = time.Now().Add(-tt.idle)
+			start := time.Now()
+			r.wait(tt.take)
+			waited := time.Since(start)
+			// tokens refill in the moments the test itself takes
+			if r.tokens < tt.want || r.tokens > tt.want+tt.rate/50 {
+				t.Errorf("%v tokens left, want %v", r.tokens, tt.want)
+			}
+			if waited < tt.minWait {
+				t.Errorf("waited %s, want at least %s", waited, tt.minWait)
+			}
+			if tt.minWait == 0 && waited > 20*time.Millisecond {
+				t.Errorf("waited %s with tokens to spare", waited)
+			}
+		})
+	}
+}
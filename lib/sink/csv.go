@@ -45,7 +45,16 @@ func (s *CsvSink) Write(wg *sync.WaitGroup) {
 	}()
 
 	for str := range s.DataChan {
-		s.Buffer.WriteString(fmt.Sprintf("%s\n", str))
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if _, err := s.Buffer.WriteString(fmt.Sprintf("%s\n", str)); err != nil {
+			s.Fail(err)
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+			}).Error("Could not write to the export file")
+		}
 		if s.Buffer.Available() <= s.BufferSize/10 {
 			s.Buffer.Flush()
 		}
@@ -0,0 +1,211 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/apex/log"
+)
+
+// Stages a row can be rejected at
+const (
+	StageExtract  = "extract"  // the mysqldump line could not be parsed
+	StageParse    = "parse"    // the values did not split into the table's columns
+	StageOversize = "oversize" // too large for the sink
+	StageDeliver  = "deliver"  // still failing once out of retries
+)
+
+// DeadLetter collects rejected rows as JSON lines so
+// they can be inspected and replayed later. Sinks of
+// the same table share the file.
+type DeadLetter struct {
+	mu   sync.Mutex
+	file *os.File
+	enc  *json.Encoder
+}
+
+type deadLetterEntry struct {
+	Time         string          `json:"time"`
+	Table        string          `json:"table"`
+	Stage        string          `json:"stage"`
+	Sink         string          `json:"sink,omitempty"`
+	Error        string          `json:"error"`
+	PartitionKey string          `json:"partition_key,omitempty"`
+	Data         json.RawMessage `json:"data,omitempty"`        // JSON payloads as they were
+	Row          string          `json:"row,omitempty"`         // raw rows as text
+	DataBase64   []byte          `json:"data_base64,omitempty"` // anything else, base64 encoded
+}
+
+var (
+	deadLetterMu     sync.Mutex
+	deadLetterFiles  = map[string]*DeadLetter{}
+	deadLetterOpened = map[string]bool{} // paths written during the run
+)
+
+// Open the dead letter file at path, or hand out the one
+// already open. The entries of an earlier run are replaced,
+// a file opened again within the run is appended to.
+func OpenDeadLetter(path string) (*DeadLetter, error) {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	if d, ok := deadLetterFiles[path]; ok {
+		return d, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if deadLetterOpened[path] {
+		flag = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	deadLetterOpened[path] = true
+	d := &DeadLetter{file: f, enc: json.NewEncoder(f)}
+	deadLetterFiles[path] = d
+	return d, nil
+}
+
+// Record a rejected row
+func (d *DeadLetter) Write(table, stage, sink, key string, data []byte, cause error) error {
+	entry := deadLetterEntry{
+		Time:         time.Now().UTC().Format(time.RFC3339),
+		Table:        table,
+		Stage:        stage,
+		Sink:         sink,
+		Error:        cause.Error(),
+		PartitionKey: key,
+	}
+	switch {
+	case json.Valid(data):
+		entry.Data = data
+	case utf8.Valid(data):
+		entry.Row = string(data)
+	default:
+		entry.DataBase64 = data
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.enc.Encode(entry)
+}
+
+// Close every dead letter file opened during the run
+func CloseDeadLetters() {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	for path, d := range deadLetterFiles {
+		d.file.Close()
+		delete(deadLetterFiles, path)
+	}
+}
+
+// Rejects counts the rows of a table turned away by the
+// extract or its sinks, records them in the table's dead
+// letter file and fails the table past the threshold.
+type Rejects struct {
+	table string
+	path  string // empty without a dead letter directory
+	max   int64  // negative for no limit
+	count int64
+
+	mu   sync.Mutex
+	file *DeadLetter // opened with the first reject
+}
+
+var (
+	rejectsMu    sync.Mutex
+	tableRejects = map[string]*Rejects{}
+)
+
+// The rejects of a table, shared by everything writing it
+func TableRejects(cfg config.Config, table string) *Rejects {
+	rejectsMu.Lock()
+	defer rejectsMu.Unlock()
+	if r, ok := tableRejects[table]; ok {
+		return r
+	}
+	dl := cfg.GetDeadLetter()
+	r := &Rejects{table: table, max: int64(dl.GetMaxRejects())}
+	if dl.IsEnabled() {
+		r.path = dl.GetPath(table)
+	}
+	tableRejects[table] = r
+	return r
+}
+
+// Reject a row. The error says the table has to fail,
+// either over the threshold or the row could not be kept.
+// Without a dead letter file the row is only logged.
+func (r *Rejects) Reject(stage, sink, key string, data []byte, cause error) error {
+	n := atomic.AddInt64(&r.count, 1)
+	log.WithFields(log.Fields{
+		"TableName": r.table,
+		"stage":     stage,
+		"sink":      sink,
+		"error":     cause,
+	}).Warn("Row rejected")
+	if r.path != "" {
+		if err := r.record(stage, sink, key, data, cause); err != nil {
+			return err
+		}
+	}
+	if r.max >= 0 && n > r.max {
+		return fmt.Errorf("%d rows rejected, over the limit of %d: %v", n, r.max, cause)
+	}
+	return nil
+}
+
+func (r *Rejects) record(stage, sink, key string, data []byte, cause error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		var err error
+		if r.file, err = OpenDeadLetter(r.path); err != nil {
+			return err
+		}
+	}
+	return r.file.Write(r.table, stage, sink, key, data, cause)
+}
+
+// Whether rejected rows are kept in a dead letter file
+func (r *Rejects) IsRecorded() bool {
+	return r.path != ""
+}
+
+func (r *Rejects) Count() int64 {
+	return atomic.LoadInt64(&r.count)
+}
+
+func (r *Rejects) Table() string {
+	return r.table
+}
+
+// The table's dead letter file, empty when not recorded
+func (r *Rejects) Path() string {
+	return r.path
+}
+
+// Close the table's dead letter file once it is done
+func (r *Rejects) Close() {
+	rejectsMu.Lock()
+	delete(tableRejects, r.table)
+	rejectsMu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	r.file.file.Close()
+	delete(deadLetterFiles, r.path)
+}
@@ -0,0 +1,348 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/structs"
+	"github.com/apex/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	DynamodbBatchItems = 25 // BatchWriteItem limit
+	DynamodbMaxRetries = 8  // attempts for items left unprocessed
+
+	DynamodbMaxItemBytes = 400 * 1024 // names and values of an item
+)
+
+// Error DynamoDB answers a batch with when one of its items is invalid
+const dynamodbValidation = "ValidationException"
+
+// DynamodbSink writes rows as items into a DynamoDB table,
+// converting the typed records into attribute values.
+type DynamodbSink struct {
+	*SinkCore
+
+	svc         *dynamodb.DynamoDB
+	table       string
+	schema      *mysqlutils.Schema
+	rejects     *Rejects
+	requests    []*dynamodb.WriteRequest
+	sources     [][]byte // row JSON of each request, for the dead letters
+	count       int64
+	unprocessed int64
+}
+
+func NewDynamodbSink(name string, cfg config.Config) *DynamodbSink {
+	d := cfg.GetDynamodb()
+	c := cfg.GetAws()
+	if d.GetEndpoint() != "" {
+		c = c.Copy().WithEndpoint(d.GetEndpoint())
+	}
+
+	sink := &DynamodbSink{
+		svc:      dynamodb.New(session.New(c)),
+		table:    d.GetTable(name),
+		requests: make([]*dynamodb.WriteRequest, 0, DynamodbBatchItems),
+		sources:  make([][]byte, 0, DynamodbBatchItems),
+		SinkCore: NewSinkCore(name, DynamodbBatchItems),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+	log.WithField("name", sink.table).Info("skrape to DynamoDB table")
+
+	_, err := sink.svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(sink.table),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException && d.IsCreateTable() {
+		err = sink.createTable()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"table":     sink.table,
+			"error":     err,
+		}).Fatal("Could not find the DynamoDB table")
+	}
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for writing
+// the exported table to DynamoDB.
+func (s *DynamodbSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully written")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if err := s.addItem(msg); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not write row to DynamoDB")
+			s.Fail(err)
+		}
+	}
+}
+
+// Write the remaining items
+func (s *DynamodbSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.writeBatch())
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"table":     s.table,
+			"error":     err,
+		}).Error("DynamoDB write failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName":   s.Name,
+		"table":       s.table,
+		"items":       s.count,
+		"unprocessed": s.unprocessed,
+	}).Info("Written to DynamoDB")
+}
+
+func (s *DynamodbSink) Close() {
+	s.SinkCore.Close()
+	s.requests = nil
+	s.sources = nil
+	s.schema = nil
+}
+
+func (s *DynamodbSink) addItem(msg string) error {
+	record, err := newRecord(s.schema, msg)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "dynamodb", "", []byte(msg), err)
+	}
+	source, err := record.Json()
+	if err != nil {
+		return err
+	}
+	item, err := s.item(record)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "dynamodb", recordKey(s.schema, record), source, err)
+	}
+	if size := itemSize(item); size > DynamodbMaxItemBytes {
+		cause := fmt.Errorf("item of %d bytes is over the DynamoDB item limit of %d", size, DynamodbMaxItemBytes)
+		return s.rejects.Reject(StageOversize, "dynamodb", recordKey(s.schema, record), source, cause)
+	}
+	s.requests = append(s.requests, &dynamodb.WriteRequest{
+		PutRequest: &dynamodb.PutRequest{Item: item},
+	})
+	s.sources = append(s.sources, source)
+	if len(s.requests) >= DynamodbBatchItems {
+		return s.writeBatch()
+	}
+	return nil
+}
+
+// Attribute values of a row
+func (s *DynamodbSink) item(record structs.Record) (map[string]*dynamodb.AttributeValue, error) {
+	for k, v := range record {
+		// nested JSON columns become maps and lists, not binary
+		if doc, ok := v.(json.RawMessage); ok {
+			var value interface{}
+			if err := json.Unmarshal(doc, &value); err != nil {
+				return nil, err
+			}
+			record[k] = value
+		}
+	}
+	return dynamodbattribute.MarshalMap(record)
+}
+
+// Size DynamoDB counts an item at: its attribute names plus
+// their values, numbers taken at their length as text
+func itemSize(item map[string]*dynamodb.AttributeValue) int {
+	size := 0
+	for name, v := range item {
+		size += len(name) + attributeSize(v)
+	}
+	return size
+}
+
+func attributeSize(v *dynamodb.AttributeValue) int {
+	switch {
+	case v.S != nil:
+		return len(*v.S)
+	case v.N != nil:
+		return len(*v.N)
+	case v.B != nil:
+		return len(v.B)
+	case v.BOOL != nil, v.NULL != nil:
+		return 1
+	case v.M != nil:
+		return 3 + itemSize(v.M)
+	case v.L != nil:
+		size := 3
+		for _, e := range v.L {
+			size += 1 + attributeSize(e)
+		}
+		return size
+	}
+	size := 0
+	for _, e := range v.SS {
+		size += len(*e)
+	}
+	for _, e := range v.NS {
+		size += len(*e)
+	}
+	for _, e := range v.BS {
+		size += len(e)
+	}
+	return size
+}
+
+// Write the queued items, retrying the unprocessed ones with
+// a doubling pause between attempts. Items still unprocessed
+// once out of retries are rejected.
+func (s *DynamodbSink) writeBatch() error {
+	if len(s.requests) == 0 {
+		return nil
+	}
+	defer func() {
+		s.requests = s.requests[:0]
+		s.sources = s.sources[:0]
+	}()
+	pending := map[string][]*dynamodb.WriteRequest{s.table: s.requests}
+	wait := 50 * time.Millisecond
+	for attempt := 0; len(pending[s.table]) > 0; attempt++ {
+		if attempt > DynamodbMaxRetries {
+			cause := fmt.Errorf("%d items still unprocessed after %d retries", len(pending[s.table]), DynamodbMaxRetries)
+			for _, r := range pending[s.table] {
+				if err := s.rejects.Reject(StageDeliver, "dynamodb", "", s.source(r), cause); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if attempt > 0 {
+			s.unprocessed += int64(len(pending[s.table]))
+			time.Sleep(wait)
+			wait *= 2
+		}
+		resp, err := s.svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+			RequestItems: pending,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbValidation {
+			// one invalid item fails the whole batch, put
+			// them one at a time so only that one is rejected
+			return s.putEach(pending[s.table])
+		}
+		if err != nil {
+			return err
+		}
+		s.count += int64(len(pending[s.table]) - len(resp.UnprocessedItems[s.table]))
+		pending = resp.UnprocessedItems
+	}
+	return nil
+}
+
+// Put the items one by one, rejecting those DynamoDB refuses
+func (s *DynamodbSink) putEach(requests []*dynamodb.WriteRequest) error {
+	for _, r := range requests {
+		_, err := s.svc.PutItem(&dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      r.PutRequest.Item,
+		})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodbValidation {
+			if err := s.rejects.Reject(StageDeliver, "dynamodb", "", s.source(r), aerr); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		s.count++
+	}
+	return nil
+}
+
+// Row JSON a request was made from. Unprocessed items come
+// back as copies, so they are matched on their values.
+func (s *DynamodbSink) source(r *dynamodb.WriteRequest) []byte {
+	for i, q := range s.requests {
+		if q == r || reflect.DeepEqual(q.PutRequest.Item, r.PutRequest.Item) {
+			return s.sources[i]
+		}
+	}
+	data, _ := json.Marshal(r.PutRequest.Item)
+	return data
+}
+
+// Create the table keyed like the MySQL table, the first
+// primary key column as hash key and the second as range key
+func (s *DynamodbSink) createTable() error {
+	keys := s.schema.PrimaryKey()
+	if len(keys) == 0 || len(keys) > 2 {
+		return fmt.Errorf("a table needs a primary key of one or two columns to be created in DynamoDB, %s has %d", s.Name, len(keys))
+	}
+
+	input := &dynamodb.CreateTableInput{
+		TableName:   aws.String(s.table),
+		BillingMode: aws.String(dynamodb.BillingModePayPerRequest),
+	}
+	keyTypes := []string{dynamodb.KeyTypeHash, dynamodb.KeyTypeRange}
+	for i, k := range keys {
+		attributeType, err := s.attributeType(k)
+		if err != nil {
+			return err
+		}
+		input.KeySchema = append(input.KeySchema, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(k),
+			KeyType:       aws.String(keyTypes[i]),
+		})
+		input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(k),
+			AttributeType: aws.String(attributeType),
+		})
+	}
+
+	log.WithField("name", s.table).Info("create table")
+	if _, err := s.svc.CreateTable(input); err != nil {
+		return err
+	}
+	return s.svc.WaitUntilTableExists(&dynamodb.DescribeTableInput{
+		TableName: aws.String(s.table),
+	})
+}
+
+// Scalar attribute type a key column is stored as, following
+// the kind its values are written as. Keys can't be booleans.
+func (s *DynamodbSink) attributeType(column string) (string, error) {
+	for i, f := range s.schema.Fields {
+		if f.Name != column {
+			continue
+		}
+		switch kind := s.schema.Kind(i); kind {
+		case mysqlutils.KindInt, mysqlutils.KindUint, mysqlutils.KindFloat, mysqlutils.KindScaled,
+			mysqlutils.KindBits, mysqlutils.KindYear:
+			return dynamodb.ScalarAttributeTypeN, nil
+		case mysqlutils.KindBool, mysqlutils.KindSet, mysqlutils.KindJson, mysqlutils.KindGeoJson:
+			return "", fmt.Errorf("key column %s is written as %s, which can't be a DynamoDB key", column, kind)
+		}
+	}
+	return dynamodb.ScalarAttributeTypeS, nil
+}
@@ -0,0 +1,344 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+)
+
+const (
+	EsBulkBytes   = 10 * 1024 * 1024 // upper bound of a _bulk request body
+	EsMaxRetries  = 5                // attempts for rejected requests and documents
+	EsBackoff     = 500 * time.Millisecond
+	EsHTTPTimeout = 2 * time.Minute
+)
+
+// ElasticsearchSink indexes rows as documents through the _bulk
+// API of Elasticsearch or OpenSearch. With an alias every run
+// loads a fresh index that replaces the old one only once the
+// table is complete, so searches never see a partial index.
+type ElasticsearchSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	client  *http.Client
+	index   string
+	alias   string
+	schema  *mysqlutils.Schema
+	rejects *Rejects
+	keys    []string
+	docs    [][]byte // action and source lines of each document
+	size    int
+	count   int64
+}
+
+type esBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func NewElasticsearchSink(name string, cfg config.Config) *ElasticsearchSink {
+	es := cfg.GetElasticsearch()
+	sink := &ElasticsearchSink{
+		Cfg:      cfg,
+		client:   &http.Client{Timeout: EsHTTPTimeout},
+		index:    es.GetIndex(name, cfg.GetRunID()),
+		alias:    es.GetAlias(name),
+		SinkCore: NewSinkCore(name, es.GetBulkSize()),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+	sink.keys = sink.schema.PrimaryKey()
+	log.WithField("index", sink.index).Info("skrape to index")
+
+	if es.IsCreateIndex() {
+		if err := sink.createIndex(); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": name,
+				"index":     sink.index,
+				"error":     err,
+			}).Fatal("Could not create the index")
+		}
+	}
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for indexing
+// the exported table.
+func (s *ElasticsearchSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully indexed")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if err := s.add(msg); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not index row")
+			s.Fail(err)
+		}
+	}
+}
+
+// Send the last bulk request and point the alias at the new index
+func (s *ElasticsearchSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.bulk())
+	}
+	if s.Err() == nil && s.alias != "" {
+		s.Fail(s.swapAlias())
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"index":     s.index,
+			"error":     err,
+		}).Error("Indexing failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"index":     s.index,
+		"alias":     s.alias,
+		"documents": s.count,
+	}).Info("Indexed")
+}
+
+func (s *ElasticsearchSink) Close() {
+	s.SinkCore.Close()
+	s.docs = nil
+	s.schema = nil
+}
+
+// Queue a row as an index action, sending the bulk
+// request first when the row would take it over a limit
+func (s *ElasticsearchSink) add(msg string) error {
+	record, err := newRecord(s.schema, msg)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "elasticsearch", "", []byte(msg), err)
+	}
+	source, err := record.Json()
+	if err != nil {
+		return err
+	}
+	meta := map[string]string{"_index": s.index}
+	if len(s.keys) > 0 {
+		meta["_id"] = recordKey(s.schema, record)
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": meta})
+	if err != nil {
+		return err
+	}
+	doc := append(append(append(action, '\n'), source...), '\n')
+
+	if len(s.docs) >= s.BufferSize || (len(s.docs) > 0 && s.size+len(doc) > EsBulkBytes) {
+		if err := s.bulk(); err != nil {
+			return err
+		}
+	}
+	s.docs = append(s.docs, doc)
+	s.size += len(doc)
+	return nil
+}
+
+// Send the queued documents. Requests and documents rejected
+// with 429 or a server error are retried with a doubling
+// pause. Any other rejected document, or one still rejected
+// once out of retries, is rejected.
+func (s *ElasticsearchSink) bulk() error {
+	pending := s.docs
+	wait := EsBackoff
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > EsMaxRetries {
+			cause := fmt.Errorf("%d documents still rejected after %d retries", len(pending), EsMaxRetries)
+			for _, doc := range pending {
+				if err := s.reject(doc, cause); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		status, body, err := s.request("POST", "/_bulk", "application/x-ndjson", bytes.Join(pending, nil))
+		if err != nil || status == http.StatusTooManyRequests || status >= 500 {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"status":    status,
+				"error":     err,
+			}).Warn("Retrying bulk request")
+			continue
+		}
+		if status >= 300 {
+			return fmt.Errorf("bulk request answered %d: %s", status, body)
+		}
+
+		var resp esBulkResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			return err
+		}
+		var retry [][]byte
+		rejected := 0
+		if resp.Errors {
+			for i, item := range resp.Items {
+				for _, result := range item {
+					if result.Status < 300 {
+						continue
+					}
+					if result.Status != http.StatusTooManyRequests && result.Status < 500 {
+						cause := fmt.Errorf("document rejected with %d: %s", result.Status, result.Error)
+						if err := s.reject(pending[i], cause); err != nil {
+							return err
+						}
+						rejected++
+						continue
+					}
+					retry = append(retry, pending[i])
+				}
+			}
+		}
+		s.count += int64(len(pending) - len(retry) - rejected)
+		pending = retry
+	}
+
+	s.docs = s.docs[:0]
+	s.size = 0
+	return nil
+}
+
+// Reject the source of a queued document
+func (s *ElasticsearchSink) reject(doc []byte, cause error) error {
+	source := doc
+	if i := bytes.IndexByte(doc, '\n'); i >= 0 {
+		source = bytes.TrimSuffix(doc[i+1:], []byte("\n"))
+	}
+	return s.rejects.Reject(StageDeliver, "elasticsearch", "", source, cause)
+}
+
+// Create the index with a mapping generated from the MySQL
+// schema, unless it already exists
+func (s *ElasticsearchSink) createIndex() error {
+	status, _, err := s.request("HEAD", "/"+s.index, "", nil)
+	if err != nil || status == http.StatusOK {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"mappings": s.schema.Mapping(),
+	})
+	if err != nil {
+		return err
+	}
+	log.WithField("name", s.index).Info("create index")
+	status, resp, err := s.request("PUT", "/"+s.index, "application/json", body)
+	if err == nil && status >= 300 {
+		err = fmt.Errorf("index creation answered %d: %s", status, resp)
+	}
+	return err
+}
+
+// Point the alias at the new index in a single atomic update,
+// removing it from the indices it pointed at before
+func (s *ElasticsearchSink) swapAlias() error {
+	if _, _, err := s.request("POST", "/"+s.index+"/_refresh", "", nil); err != nil {
+		return err
+	}
+
+	var old []string
+	status, body, err := s.request("GET", "/_alias/"+s.alias, "", nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		current := map[string]interface{}{}
+		if err := json.Unmarshal(body, &current); err != nil {
+			return err
+		}
+		for index := range current {
+			if index != s.index {
+				old = append(old, index)
+			}
+		}
+	}
+
+	actions := []map[string]interface{}{}
+	for _, index := range old {
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]string{"index": index, "alias": s.alias},
+		})
+	}
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]string{"index": s.index, "alias": s.alias},
+	})
+	update, err := json.Marshal(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return err
+	}
+	status, body, err = s.request("POST", "/_aliases", "application/json", update)
+	if err == nil && status >= 300 {
+		err = fmt.Errorf("alias update answered %d: %s", status, body)
+	}
+	if err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"alias": s.alias,
+		"index": s.index,
+		"old":   strings.Join(old, ", "),
+	}).Info("Alias swapped")
+
+	if s.Cfg.GetElasticsearch().IsDeleteOld() && len(old) > 0 {
+		status, body, err = s.request("DELETE", "/"+strings.Join(old, ","), "", nil)
+		if err == nil && status >= 300 {
+			err = fmt.Errorf("deleting old indices answered %d: %s", status, body)
+		}
+		if err != nil {
+			// the swap itself worked, the table is not failed for this
+			log.WithFields(log.Fields{
+				"indices": strings.Join(old, ", "),
+				"error":   err,
+			}).Warn("Could not delete old indices")
+		}
+	}
+	return nil
+}
+
+// Send a request to the cluster. Credentials in the URL
+// are sent as basic auth.
+func (s *ElasticsearchSink) request(method, path, contentType string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, s.Cfg.GetElasticsearch().GetURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
+}
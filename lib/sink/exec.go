@@ -0,0 +1,157 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+)
+
+// ExecSink pipes the rows of a table into the stdin of an
+// external command, started once per table through the shell.
+// The command learns what it is reading from the environment:
+//
+//	SKRAPE_TABLE     name of the table
+//	SKRAPE_DATABASE  name of the source database
+//	SKRAPE_SCHEMA    path of a file holding the table schema as JSON
+//	SKRAPE_FORMAT    csv or jsonl
+//	SKRAPE_RUN_ID    id of the run
+//
+// A non-zero exit fails the table.
+type ExecSink struct {
+	*SinkCore
+
+	Buffer     *bufio.Writer
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	format     string
+	schema     *mysqlutils.Schema
+	rejects    *Rejects
+	schemaPath string
+	count      int64
+}
+
+func NewExecSink(name string, cfg config.Config) *ExecSink {
+	x := cfg.GetExec()
+	sink := &ExecSink{
+		format:   x.GetFormat(),
+		SinkCore: NewSinkCore(name, StreamBufferSize),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+
+	var err error
+	sink.schemaPath, err = writeSchemaFile(sink.schema, cfg.GetConn().Database, name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Fatal("Could not write the schema file for the command")
+	}
+
+	sink.cmd = exec.Command("sh", "-c", x.GetCommand())
+	sink.cmd.Env = append(os.Environ(),
+		"SKRAPE_TABLE="+name,
+		"SKRAPE_DATABASE="+cfg.GetConn().Database,
+		"SKRAPE_SCHEMA="+sink.schemaPath,
+		"SKRAPE_FORMAT="+sink.format,
+		"SKRAPE_RUN_ID="+cfg.GetRunID(),
+	)
+	sink.cmd.Stdout = os.Stdout
+	sink.cmd.Stderr = os.Stderr
+	if sink.stdin, err = sink.cmd.StdinPipe(); err == nil {
+		err = sink.cmd.Start()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"command":   x.GetCommand(),
+			"error":     err,
+		}).Fatal("Could not start the command")
+	}
+	sink.Buffer = bufio.NewWriterSize(sink.stdin, StreamBufferSize)
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for writing
+// the exported table to the command's stdin.
+func (s *ExecSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		if s.Err() == nil {
+			s.Fail(s.Buffer.Flush())
+		}
+		// closing stdin tells the command the table is complete
+		s.stdin.Close()
+		wg.Done()
+		log.Debug("Channel closed, table should be fully piped")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		row, err := encodeRow(s.format, s.schema, msg)
+		if err != nil {
+			s.Fail(s.rejects.Reject(StageParse, "exec", "", []byte(msg), err))
+			continue
+		}
+		row = append(row, '\n')
+		if _, err := s.Buffer.Write(row); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+			}).Error("Could not write row to the command")
+			s.Fail(err)
+			continue
+		}
+		s.count++
+	}
+}
+
+// Wait for the command, its exit status decides the table
+func (s *ExecSink) ReadFinished() {
+	if err := s.cmd.Wait(); err != nil {
+		s.Fail(fmt.Errorf("command failed: %s", err))
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("Command did not take the table")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"rows":      s.count,
+	}).Info("Piped to command")
+}
+
+func (s *ExecSink) Close() {
+	s.SinkCore.Close()
+	os.Remove(s.schemaPath)
+	s.Buffer = nil
+	s.schema = nil
+}
+
+// Write the schema to a temporary file the command can read
+func writeSchemaFile(schema *mysqlutils.Schema, database, name string) (string, error) {
+	f, err := ioutil.TempFile("", fmt.Sprintf("skrape-%s-%s-", database, name))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(schema); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
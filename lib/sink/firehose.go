@@ -0,0 +1,208 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/firehose"
+)
+
+// PutRecordBatch limits
+const (
+	FirehoseBatchRecords = 500
+	FirehoseBatchBytes   = 4 * 1024 * 1024 // 4 MiB per call
+	FirehoseRecordBytes  = 1000 * 1024     // 1000 KiB per record
+	FirehoseMaxRetries   = 5               // attempts for entries that keep failing
+)
+
+// FirehoseSink sends rows as JSON records to a Kinesis Data
+// Firehose delivery stream, one stream per table when the
+// name contains {TABLE_NAME}.
+type FirehoseSink struct {
+	*SinkCore
+
+	schema    *mysqlutils.Schema
+	rejects   *Rejects
+	svc       *firehose.Firehose
+	stream    string
+	records   []*firehose.Record
+	size      int // bytes in records
+	putCount  int64
+	failCount int64
+}
+
+func NewFirehoseSink(name string, cfg config.Config) *FirehoseSink {
+	f := cfg.GetFirehose()
+	c := cfg.GetAws()
+	if f.GetEndpoint() != "" {
+		c = c.Copy().WithEndpoint(f.GetEndpoint())
+	}
+	svc := firehose.New(session.New(c))
+
+	stream := f.GetStream(name)
+	log.WithField("name", stream).Info("skrape to delivery stream")
+
+	// delivery streams need a destination, they are never created here
+	_, err := svc.DescribeDeliveryStream(&firehose.DescribeDeliveryStreamInput{
+		DeliveryStreamName: aws.String(stream),
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"stream": stream,
+			"error":  err,
+		}).Fatal("Could not find the Firehose delivery stream")
+	}
+
+	sink := &FirehoseSink{
+		svc:      svc,
+		stream:   stream,
+		records:  make([]*firehose.Record, 0, FirehoseBatchRecords),
+		SinkCore: NewSinkCore(name, FirehoseBatchRecords),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for sending
+// the exported table to the delivery stream.
+func (s *FirehoseSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully delivered")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if err := s.addRecord(msg); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not send row to Firehose")
+			s.Fail(err)
+		}
+	}
+}
+
+// Send the remaining records
+func (s *FirehoseSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.putRecords())
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"stream":    s.stream,
+			"error":     err,
+		}).Error("Firehose delivery failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"stream":    s.stream,
+		"records":   s.putCount,
+		"retried":   s.failCount,
+	}).Info("Sent to Firehose")
+}
+
+func (s *FirehoseSink) Close() {
+	s.SinkCore.Close()
+	s.records = nil
+	s.schema = nil
+}
+
+// Queue a row as a newline terminated JSON document, sending
+// the batch first when the row would take it over a limit
+func (s *FirehoseSink) addRecord(msg string) error {
+	record, err := newRecord(s.schema, msg)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "firehose", "", []byte(msg), err)
+	}
+	record["deltatype"] = "1" // Create record
+
+	jsn, err := record.Json()
+	if err != nil {
+		return err
+	}
+	// Firehose concatenates records, the newline keeps them apart in S3
+	data := append(jsn, '\n')
+	if len(data) > FirehoseRecordBytes {
+		cause := fmt.Errorf("record of %d bytes is over the Firehose limit of %d", len(data), FirehoseRecordBytes)
+		return s.rejects.Reject(StageOversize, "firehose", "", jsn, cause)
+	}
+
+	if len(s.records) >= FirehoseBatchRecords || s.size+len(data) > FirehoseBatchBytes {
+		if err := s.putRecords(); err != nil {
+			return err
+		}
+	}
+	s.records = append(s.records, &firehose.Record{Data: data})
+	s.size += len(data)
+	return nil
+}
+
+// Send the queued records, retrying the entries Firehose
+// rejected with a growing pause between attempts. Entries
+// still failing once out of retries are rejected.
+func (s *FirehoseSink) putRecords() error {
+	pending := s.records
+	wait := 100 * time.Millisecond
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > FirehoseMaxRetries {
+			cause := fmt.Errorf("%d records still failing after %d retries", len(pending), FirehoseMaxRetries)
+			for _, r := range pending {
+				if err := s.rejects.Reject(StageDeliver, "firehose", "", r.Data, cause); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		resp, err := s.svc.PutRecordBatch(&firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(s.stream),
+			Records:            pending,
+		})
+		if err != nil {
+			return err
+		}
+
+		var retry []*firehose.Record
+		if aws.Int64Value(resp.FailedPutCount) > 0 {
+			for i, r := range resp.RequestResponses {
+				if r.ErrorCode != nil {
+					log.WithFields(log.Fields{
+						"TableName": s.Name,
+						"Error":     aws.StringValue(r.ErrorCode),
+					}).Debug("failed record")
+					retry = append(retry, pending[i])
+				}
+			}
+			s.failCount += int64(len(retry))
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"count":     len(retry),
+			}).Warn("failed records")
+		}
+		s.putCount += int64(len(pending) - len(retry))
+		pending = retry
+	}
+
+	s.records = s.records[:0]
+	s.size = 0
+	return nil
+}
@@ -0,0 +1,258 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+)
+
+const (
+	HttpTimeout     = 60 * time.Second
+	HttpBackoff     = 500 * time.Millisecond // first pause before a retry
+	HttpMaxBackoff  = 30 * time.Second
+	IdempotencyName = "Idempotency-Key"
+)
+
+// HttpSink posts the rows of a table to a webhook as JSON
+// arrays of typed records. Every batch carries an idempotency
+// key made of the run id, table and batch number so a receiver
+// can drop a batch it already took when skrape retries it.
+type HttpSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	client   *http.Client
+	url      string
+	schema   *mysqlutils.Schema
+	rejects  *Rejects
+	records  [][]byte
+	size     int // bytes in records
+	batch    int // number of the next batch
+	count    int64
+	attempts int64
+}
+
+func NewHttpSink(name string, cfg config.Config) *HttpSink {
+	hook := cfg.GetHttp()
+	sink := &HttpSink{
+		Cfg:      cfg,
+		client:   &http.Client{Timeout: HttpTimeout},
+		url:      hook.GetURL(name),
+		SinkCore: NewSinkCore(name, hook.GetBatchSize()),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+	log.WithField("url", sink.url).Info("skrape to webhook")
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for posting
+// the exported table to the webhook.
+func (s *HttpSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully posted")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if err := s.add(msg); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not post row to the webhook")
+			s.Fail(err)
+		}
+	}
+}
+
+// Post the last batch
+func (s *HttpSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.post())
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"url":       s.url,
+			"error":     err,
+		}).Error("Webhook delivery failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"rows":      s.count,
+		"batches":   s.batch,
+		"requests":  s.attempts,
+	}).Info("Posted to webhook")
+}
+
+func (s *HttpSink) Close() {
+	s.SinkCore.Close()
+	s.records = nil
+	s.schema = nil
+}
+
+// Queue a row, posting the batch first when the
+// row would take it over the size limits
+func (s *HttpSink) add(msg string) error {
+	record, err := newRecord(s.schema, msg)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "http", "", []byte(msg), err)
+	}
+	jsn, err := record.Json()
+	if err != nil {
+		return err
+	}
+	if len(s.records) >= s.BufferSize || (len(s.records) > 0 && s.size+len(jsn) > s.Cfg.GetHttp().GetBatchBytes()) {
+		if err := s.post(); err != nil {
+			return err
+		}
+	}
+	s.records = append(s.records, jsn)
+	s.size += len(jsn) + 1
+	return nil
+}
+
+// Post the queued rows, retrying on 429, 5xx and network
+// errors with a doubling pause. Retry-After is honoured.
+// The rows of a batch that can't be posted are rejected.
+func (s *HttpSink) post() error {
+	if len(s.records) == 0 {
+		return nil
+	}
+	hook := s.Cfg.GetHttp()
+	body, err := s.body(hook.IsGzip())
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s-%s-%d", s.Cfg.GetRunID(), s.Name, s.batch)
+
+	wait := HttpBackoff
+	for attempt := 0; ; attempt++ {
+		s.attempts++
+		status, retryAfter, err := s.send(body, key)
+		if err == nil && status < 300 {
+			break
+		}
+		if err == nil {
+			err = fmt.Errorf("webhook answered %d for batch %d", status, s.batch)
+			if status != http.StatusTooManyRequests && status < 500 {
+				return s.rejectBatch(key, err) // the request itself is wrong, retrying won't help
+			}
+		}
+		if attempt >= hook.GetMaxRetries() {
+			return s.rejectBatch(key, err)
+		}
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"batch":     s.batch,
+			"error":     err,
+			"wait":      wait.String(),
+		}).Warn("Retrying webhook batch")
+		time.Sleep(wait)
+		if wait *= 2; wait > HttpMaxBackoff {
+			wait = HttpMaxBackoff
+		}
+	}
+
+	s.count += int64(len(s.records))
+	s.nextBatch()
+	return nil
+}
+
+func (s *HttpSink) rejectBatch(key string, cause error) error {
+	for _, r := range s.records {
+		if err := s.rejects.Reject(StageDeliver, "http", key, r, cause); err != nil {
+			return err
+		}
+	}
+	s.nextBatch()
+	return nil
+}
+
+func (s *HttpSink) nextBatch() {
+	s.batch++
+	s.records = s.records[:0]
+	s.size = 0
+}
+
+// One attempt at posting a batch. Returns the status
+// code and how long the server asked us to wait.
+func (s *HttpSink) send(body []byte, key string) (int, time.Duration, error) {
+	hook := s.Cfg.GetHttp()
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if hook.IsGzip() {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set(IdempotencyName, key)
+	req.Header.Set("X-Skrape-Table", s.Name)
+	if user, pwd := hook.GetBasicAuth(); user != "" {
+		req.SetBasicAuth(user, pwd)
+	}
+	if token := hook.GetToken(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for name, value := range hook.GetHeaders() {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) // lets the connection be reused
+
+	var retryAfter time.Duration
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(secs) * time.Second
+	}
+	return resp.StatusCode, retryAfter, nil
+}
+
+// The queued rows as a JSON array, gzipped when asked to
+func (s *HttpSink) body(compress bool) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gw *gzip.Writer
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		w = gw
+	}
+	w.Write([]byte{'['})
+	for i, r := range s.records {
+		if i > 0 {
+			w.Write([]byte{','})
+		}
+		w.Write(r)
+	}
+	w.Write([]byte{']'})
+	if gw != nil {
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
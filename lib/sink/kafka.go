@@ -0,0 +1,218 @@
+package sink
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/Shopify/sarama"
+	"github.com/apex/log"
+)
+
+// KafkaSink publishes rows as JSON records to a Kafka topic,
+// keyed by primary key so updates of a row stay in order.
+type KafkaSink struct {
+	*SinkCore
+
+	schema    *mysqlutils.Schema
+	rejects   *Rejects
+	producer  sarama.AsyncProducer
+	topic     string
+	results   sync.WaitGroup
+	sentCount int64
+	errCount  int64
+}
+
+func NewKafkaSink(name string, cfg config.Config) *KafkaSink {
+	k := cfg.GetKafka()
+	kcfg, err := kafkaConfig(cfg)
+	if err != nil {
+		log.WithField("error", err).Fatal("Invalid Kafka producer settings")
+	}
+
+	topic := k.GetTopic(name)
+	log.WithField("name", topic).Info("skrape to topic")
+	if k.IsCreateTopic() {
+		if err := createTopic(cfg, kcfg, topic); err != nil {
+			log.WithFields(log.Fields{
+				"topic": topic,
+				"error": err,
+			}).Fatal("Could not create the Kafka topic")
+		}
+	}
+
+	producer, err := sarama.NewAsyncProducer(k.GetBrokers(), kcfg)
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not connect to Kafka")
+	}
+
+	sink := &KafkaSink{
+		producer: producer,
+		topic:    topic,
+		SinkCore: NewSinkCore(name, k.GetBatchSize()),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+
+	// both channels must be drained or the producer blocks
+	sink.results.Add(2)
+	go func() {
+		defer sink.results.Done()
+		for range producer.Successes() {
+			sink.sentCount++
+		}
+	}()
+	go func() {
+		defer sink.results.Done()
+		for err := range producer.Errors() {
+			sink.errCount++
+			log.WithFields(log.Fields{
+				"TableName": name,
+				"error":     err.Err,
+			}).Warn("failed message")
+			sink.Fail(sink.reject(err))
+		}
+	}()
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for publishing
+// the exported table to the topic.
+func (s *KafkaSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully published")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		record, err := newRecord(s.schema, msg)
+		if err != nil {
+			s.Fail(s.rejects.Reject(StageParse, "kafka", "", []byte(msg), err))
+			continue
+		}
+		record["deltatype"] = "1" // Create record
+		jsn, err := record.Json()
+		if err != nil {
+			s.Fail(err)
+			continue
+		}
+		message := &sarama.ProducerMessage{
+			Topic: s.topic,
+			Value: sarama.ByteEncoder(jsn),
+		}
+		if key := recordKey(s.schema, record); key != "" {
+			message.Key = sarama.StringEncoder(key)
+		} // keyless rows are spread over the partitions
+		s.producer.Input() <- message
+	}
+}
+
+// Flush the buffered messages and wait for every acknowledgement
+func (s *KafkaSink) ReadFinished() {
+	s.producer.AsyncClose()
+	s.results.Wait()
+
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"topic":     s.topic,
+			"failed":    s.errCount,
+			"error":     err,
+		}).Error("Kafka publish failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"topic":     s.topic,
+		"messages":  s.sentCount,
+	}).Info("Published to Kafka")
+}
+
+// Reject a message the producer gave up on once out of retries
+func (s *KafkaSink) reject(perr *sarama.ProducerError) error {
+	var key, value []byte
+	if perr.Msg.Key != nil {
+		key, _ = perr.Msg.Key.Encode()
+	}
+	if perr.Msg.Value != nil {
+		value, _ = perr.Msg.Value.Encode()
+	}
+	return s.rejects.Reject(StageDeliver, "kafka", string(key), value, perr.Err)
+}
+
+func (s *KafkaSink) Close() {
+	s.SinkCore.Close()
+	s.schema = nil
+}
+
+// Translate the cli settings into a producer config
+func kafkaConfig(cfg config.Config) (*sarama.Config, error) {
+	k := cfg.GetKafka()
+	c := sarama.NewConfig()
+	c.ClientID = "skrape"
+	c.Version = sarama.V0_11_0_0
+	c.Producer.Return.Successes = true
+
+	switch k.GetAcks() {
+	case config.KafkaAcksNone:
+		c.Producer.RequiredAcks = sarama.NoResponse
+	case config.KafkaAcksLeader:
+		c.Producer.RequiredAcks = sarama.WaitForLocal
+	default:
+		c.Producer.RequiredAcks = sarama.WaitForAll
+	}
+
+	switch k.GetCompression() {
+	case "gzip":
+		c.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		c.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		c.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		c.Producer.Compression = sarama.CompressionZSTD
+		c.Version = sarama.V2_1_0_0 // first version that knows zstd
+	}
+
+	if k.GetBatchSize() > 0 {
+		c.Producer.Flush.Messages = k.GetBatchSize()
+	}
+	c.Producer.Flush.Frequency = k.GetLinger()
+
+	if k.IsIdempotent() {
+		if k.GetAcks() != config.KafkaAcksAll {
+			return nil, fmt.Errorf("an idempotent producer needs --acks %s", config.KafkaAcksAll)
+		}
+		c.Producer.Idempotent = true
+		c.Net.MaxOpenRequests = 1
+	}
+	return c, c.Validate()
+}
+
+// Create the topic unless it already exists
+func createTopic(cfg config.Config, c *sarama.Config, topic string) error {
+	k := cfg.GetKafka()
+	admin, err := sarama.NewClusterAdmin(k.GetBrokers(), c)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	topics, err := admin.ListTopics()
+	if err != nil {
+		return err
+	}
+	if _, ok := topics[topic]; ok {
+		return nil
+	}
+	log.WithField("name", topic).Info("create topic")
+	return admin.CreateTopic(topic, &sarama.TopicDetail{
+		NumPartitions:     int32(k.GetPartitions()),
+		ReplicationFactor: int16(k.GetReplication()),
+	}, false)
+}
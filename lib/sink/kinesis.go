@@ -1,9 +1,7 @@
 package sink
 
 import (
-	"encoding/csv"
-	"strconv"
-	"strings"
+	"fmt"
 	"sync"
 	"time"
 
@@ -13,6 +11,7 @@ import (
 	"github.com/apex/log"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 )
@@ -28,13 +27,42 @@ type KinesisSink struct {
 	kinesisPutCount int64
 	kinesisErrCount int64
 	tickerDoneChan  chan bool
+	aggregator      *aggregator // nil unless rows are packed into KPL records
+	partition       *partitioner
+	limiter         *shardLimiter // nil when rate limiting is off
+	rejects         *Rejects
+	maxRetries      int
+	oversize        string
+	truncateCols    []string
+	oversizeSeq     int64 // numbers the oversized rows split or offloaded
+	Cfg             config.Config
 }
 
+// Per-shard write limits of a Kinesis stream
+const (
+	KinesisShardRecords = 1000        // records a second
+	KinesisShardBytes   = 1024 * 1024 // bytes a second
+	KinesisBackoff      = 100 * time.Millisecond
+	KinesisMaxBackoff   = 10 * time.Second
+)
+
+// shardLimiter holds the puts to a stream to what its shards
+// take. Tables writing to the same stream share one limiter.
+type shardLimiter struct {
+	records *rateLimiter
+	bytes   *rateLimiter
+}
+
+var (
+	shardLimitersMu sync.Mutex
+	shardLimiters   = map[string]*shardLimiter{}
+)
+
 func NewKinesisSink(path, name string, batchSize int, cfg config.Config) *KinesisSink {
 	k := cfg.GetKinesis()
 	c := cfg.GetAws()
 	if k.GetEndpoint() != "" {
-		c = c.WithEndpoint(k.GetEndpoint())
+		c = c.Copy().WithEndpoint(k.GetEndpoint())
 	}
 	sess := session.New(c)
 	svc := kinesis.New(sess)
@@ -49,22 +77,45 @@ func NewKinesisSink(path, name string, batchSize int, cfg config.Config) *Kinesi
 		tickerDoneChan: make(chan bool),
 		SinkCore:       NewSinkCore(name, batchSize),
 	}
+	if k.IsAggregate() {
+		sink.aggregator = newAggregator()
+	}
 
-	params := &kinesis.DescribeStreamInput{
-		StreamName: aws.String(stream), // Required
+	err := sink.prepareStream(cfg)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"stream": stream,
+			"error":  err,
+		}).Fatal("Could not prepare the stream")
 	}
-	_, err := svc.DescribeStream(params)
 
+	sink.schema = TableSchema(cfg, name)
+	sink.partition, err = newPartitioner(k.GetPartitionKey(name), sink.schema)
+	if err == nil && sink.partition.strategy == config.PartitionRoundRobin {
+		sink.partition.shards, err = sink.openShards()
+	}
 	if err != nil {
-		// try creating the stream
-		err = sink.createStream(stream, k.GetShardCount())
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Fatal("Could not set up the partition key")
+	}
+
+	sink.maxRetries = k.GetMaxRetries()
+	sink.oversize = k.GetOversize()
+	sink.truncateCols = k.GetTruncateColumns()
+	sink.Cfg = cfg
+	sink.rejects = TableRejects(cfg, name)
+	if k.IsRateLimit() {
+		sink.limiter, err = sink.shardLimiter()
 		if err != nil {
-			panic(err)
+			log.WithFields(log.Fields{
+				"stream": stream,
+				"error":  err,
+			}).Fatal("Could not read the shard count of the stream")
 		}
 	}
 
-	sink.schema, _ = mysqlutils.TableSchema(cfg.GetConn(), name)
-
 	tickChan := time.NewTicker(time.Second * 10).C
 	go func() {
 		for {
@@ -95,17 +146,20 @@ func (s *KinesisSink) Write(wg *sync.WaitGroup) {
 	}()
 
 	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
 		err := s.addRecord(msg)
 		if err != nil {
-			log.WithFields(log.Fields{"err": err, "msg": msg}).Warn("Add record error")
-			return
+			s.Fail(s.rejects.Reject(StageParse, "kinesis", "", []byte(msg), err))
+			continue
 		}
 
 		if len(s.records) >= s.BufferSize {
 			err := s.putRecords()
 			if err != nil {
 				log.WithField("err", err).Warn("Put record error")
-				return
+				s.Fail(err)
 			}
 		}
 	}
@@ -113,9 +167,17 @@ func (s *KinesisSink) Write(wg *sync.WaitGroup) {
 
 // Write out the remaining messages to Kinesis
 func (s *KinesisSink) ReadFinished() {
-	s.putRecords()
+	if s.Err() == nil {
+		s.Fail(s.putRecords())
+	}
+	if s.Err() == nil {
+		s.Fail(s.flushAggregate())
+	}
 	s.tickerDoneChan <- true
-	log.WithField("count", len(s.records)).Info("Record count")
+	log.WithFields(log.Fields{
+		"count":    len(s.records),
+		"rejected": s.rejects.Count(),
+	}).Info("Record count")
 }
 
 func (s *KinesisSink) Close() {
@@ -125,79 +187,165 @@ func (s *KinesisSink) Close() {
 }
 
 func (s *KinesisSink) addRecord(msg string) error {
-	values, err := s.split(msg)
+	record, err := newRecord(s.schema, msg)
 	if err != nil {
 		return err
 	}
-	record := structs.Record{}
 	record["deltatype"] = "1" // Create record
 
-	for i, field := range s.schema.Fields {
-		var v interface{}
-		val := values[i]
-		fieldType := field.Type
-		if strings.Contains(field.Type, "(") {
-			fieldType = fieldType[:strings.Index(fieldType, "(")]
-		}
-		switch fieldType {
-		case "tinyint", "smallint", "mediumint", "int", "bigint":
-			v, _ = strconv.ParseInt(val, 10, 64)
-		case "float", "double", "decimal":
-			v, _ = strconv.ParseFloat(val, 64)
-		default:
-			if val != "NULL" {
-				v = val
-			}
-		}
-		record[field.Name] = v
-	}
 	s.records = append(s.records, &record)
 	log.WithField("record", record).Debug("New record")
 	return nil
 }
 
+// Turn the buffered records into put entries and send them.
+// With aggregation the rows are packed into KPL records and
+// the last, partly filled one waits for more rows.
 func (ks *KinesisSink) putRecords() error {
-	var recordsToDump []*structs.Record
-
-	// chunk up big batches in case we get a failure and need to retry
-	for len(ks.records) > 0 {
-		if len(ks.records) > 500 {
-			recordsToDump = ks.records[:500]
-			ks.records = ks.records[500:]
-		} else {
-			recordsToDump = ks.records[:]
-			ks.records = ks.records[:0]
+	var entries []*kinesis.PutRecordsRequestEntry
+	for _, r := range ks.records {
+		jsn, err := r.Json()
+		if err != nil {
+			return err
 		}
-
-		retryIdx, err := ks._dump(recordsToDump)
+		log.WithField("dump:", (*r).String()).Debug("record")
+		key := ks.partition.key(*r)
+		payloads, err := ks.fit(*r, key, jsn)
 		if err != nil {
 			return err
 		}
+		var hashKey *string
+		if ks.aggregator == nil && len(payloads) > 0 {
+			hashKey = ks.partition.hashKey() // the chunks of a split row stay on one shard
+		}
+		for _, data := range payloads {
+			if ks.aggregator == nil {
+				entries = append(entries, &kinesis.PutRecordsRequestEntry{
+					Data:            data,            // Required
+					PartitionKey:    aws.String(key), // Required
+					ExplicitHashKey: hashKey,
+				})
+				continue
+			}
+			if !ks.aggregator.fits(key, data) {
+				entries = append(entries, ks.aggregateEntry())
+			}
+			ks.aggregator.add(key, data)
+		}
+	}
+	ks.records = ks.records[:0]
+	return ks.putEntries(entries)
+}
+
+// Send the aggregated record still being filled
+func (ks *KinesisSink) flushAggregate() error {
+	if ks.aggregator == nil || ks.aggregator.empty() {
+		return nil
+	}
+	return ks.putEntries([]*kinesis.PutRecordsRequestEntry{ks.aggregateEntry()})
+}
+
+func (ks *KinesisSink) aggregateEntry() *kinesis.PutRecordsRequestEntry {
+	entry := ks.aggregator.entry()
+	entry.ExplicitHashKey = ks.partition.hashKey()
+	return entry
+}
 
-		for _, idx := range retryIdx {
-			// prepend any records to retry
-			ks.records = append(recordsToDump[idx:idx+1], ks.records...)
+// Send the entries in requests of at most 500 records and
+// 5 MiB. Failed entries are retried with a jittered backoff
+// and go to the dead letter file once out of retries.
+func (ks *KinesisSink) putEntries(entries []*kinesis.PutRecordsRequestEntry) error {
+	for len(entries) > 0 {
+		n, size := 0, 0
+		for n < len(entries) && n < KinesisMaxPutRecords {
+			size += len(entries[n].Data) + len(*entries[n].PartitionKey)
+			if n > 0 && size > KinesisMaxPutBytes {
+				break
+			}
+			n++
 		}
+
+		pending := entries[:n]
+		bo := newBackoff(KinesisBackoff, KinesisMaxBackoff)
+		for attempt := 0; len(pending) > 0; attempt++ {
+			if attempt > ks.maxRetries {
+				if err := ks.deadLetterEntries(pending); err != nil {
+					return err
+				}
+				break
+			}
+			if attempt > 0 {
+				time.Sleep(bo.next())
+			}
+			ks.throttle(pending)
+			retry, err := ks._dump(pending)
+			if err != nil {
+				if aerr, ok := err.(awserr.Error); ok && aerr.Code() == kinesis.ErrCodeProvisionedThroughputExceededException {
+					continue // the whole request was throttled
+				}
+				return err
+			}
+			pending = retry
+		}
+		entries = entries[n:]
 	}
 
 	return nil
 }
 
-func (ks *KinesisSink) _dump(recordsToDump []*structs.Record) (retryIdx []int, err error) {
-	params := &kinesis.PutRecordsInput{
-		StreamName: aws.String(ks.stream), // Required
+// Wait until the stream's shards can take the entries
+func (ks *KinesisSink) throttle(entries []*kinesis.PutRecordsRequestEntry) {
+	if ks.limiter == nil {
+		return
 	}
+	size := 0
+	for _, e := range entries {
+		size += len(e.Data) + len(*e.PartitionKey)
+	}
+	ks.limiter.records.wait(len(entries))
+	ks.limiter.bytes.wait(size)
+}
 
-	for _, r := range recordsToDump {
-		jsn, err := r.Json()
-		if err != nil {
-			return retryIdx, err
+// Reject entries that ran out of retries
+func (ks *KinesisSink) deadLetterEntries(entries []*kinesis.PutRecordsRequestEntry) error {
+	cause := fmt.Errorf("%d records still failing after %d retries", len(entries), ks.maxRetries)
+	for _, e := range entries {
+		if err := ks.rejects.Reject(StageDeliver, "kinesis", aws.StringValue(e.PartitionKey), e.Data, cause); err != nil {
+			return err
 		}
-		params.Records = append(params.Records, &kinesis.PutRecordsRequestEntry{
-			Data:         jsn,                   // Required
-			PartitionKey: aws.String(r.GetID()), // Required
-		})
-		log.WithField("dump:", (*r).String()).Debug("record")
+	}
+	return nil
+}
+
+// The limiter of the stream, sized by its open shard count
+func (ks *KinesisSink) shardLimiter() (*shardLimiter, error) {
+	shardLimitersMu.Lock()
+	defer shardLimitersMu.Unlock()
+	if l, ok := shardLimiters[ks.stream]; ok {
+		return l, nil
+	}
+	resp, err := ks.svc.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(ks.stream),
+	})
+	if err != nil {
+		return nil, err
+	}
+	shards := float64(aws.Int64Value(resp.StreamDescriptionSummary.OpenShardCount))
+	if shards < 1 {
+		shards = 1
+	}
+	l := &shardLimiter{
+		records: newRateLimiter(shards * KinesisShardRecords),
+		bytes:   newRateLimiter(shards * KinesisShardBytes),
+	}
+	shardLimiters[ks.stream] = l
+	return l, nil
+}
+
+func (ks *KinesisSink) _dump(entries []*kinesis.PutRecordsRequestEntry) (retry []*kinesis.PutRecordsRequestEntry, err error) {
+	params := &kinesis.PutRecordsInput{
+		StreamName: aws.String(ks.stream), // Required
+		Records:    entries,
 	}
 	resp, err := ks.svc.PutRecords(params)
 
@@ -210,7 +358,7 @@ func (ks *KinesisSink) _dump(recordsToDump []*structs.Record) (retryIdx []int, e
 	// Pretty-print the response data.
 	log.WithField("resp", resp).Debug("Kinesis response")
 
-	putCount := int64(len(recordsToDump))
+	putCount := int64(len(entries))
 	if *resp.FailedRecordCount > 0 {
 		ks.kinesisErrCount += *resp.FailedRecordCount
 		putCount -= *resp.FailedRecordCount
@@ -224,9 +372,9 @@ func (ks *KinesisSink) _dump(recordsToDump []*structs.Record) (retryIdx []int, e
 			if r.ErrorCode != nil { // on either error, retry
 				log.WithFields(log.Fields{
 					"Error": *r.ErrorCode,
-				}).Warn("failed record")
+				}).Debug("failed record")
 
-				retryIdx = append(retryIdx, i)
+				retry = append(retry, entries[i])
 			}
 		}
 	}
@@ -235,6 +383,31 @@ func (ks *KinesisSink) _dump(recordsToDump []*structs.Record) (retryIdx []int, e
 	return
 }
 
+// Starting hash keys of the open shards
+func (ks *KinesisSink) openShards() ([]string, error) {
+	var starts []string
+	params := &kinesis.DescribeStreamInput{StreamName: aws.String(ks.stream)}
+	for {
+		resp, err := ks.svc.DescribeStream(params)
+		if err != nil {
+			return nil, err
+		}
+		for _, shard := range resp.StreamDescription.Shards {
+			if shard.SequenceNumberRange.EndingSequenceNumber == nil { // closed shards have an end
+				starts = append(starts, aws.StringValue(shard.HashKeyRange.StartingHashKey))
+			}
+			params.ExclusiveStartShardId = shard.ShardId
+		}
+		if !aws.BoolValue(resp.StreamDescription.HasMoreShards) {
+			break
+		}
+	}
+	if len(starts) == 0 {
+		return nil, fmt.Errorf("stream %s has no open shards", ks.stream)
+	}
+	return starts, nil
+}
+
 func (ks *KinesisSink) createStream(streamName string, shardCount int) error {
 	log.WithField("name", streamName).Info("create stream")
 	params := &kinesis.CreateStreamInput{
@@ -248,9 +421,3 @@ func (ks *KinesisSink) createStream(streamName string, shardCount int) error {
 	}
 	return nil
 }
-
-func (ks *KinesisSink) split(msg string) ([]string, error) {
-	r := csv.NewReader(strings.NewReader(msg))
-
-	return r.Read()
-}
@@ -0,0 +1,358 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL allows at most this many placeholders per statement
+const MysqlMaxPlaceholders = 65535
+
+// How the values of a column travel to the target server
+const (
+	mysqlText     = iota
+	mysqlBinary   // blobs, sent as bytes, hex for LOAD DATA
+	mysqlGeometry // EWKT turned back into the internal format, sent like blobs
+	mysqlBits     // b'0101' literals, sent as unsigned integers
+)
+
+// MysqlSink copies rows into a table on a second MySQL server,
+// either streamed through LOAD DATA LOCAL INFILE or as batched
+// multi-row INSERT statements.
+type MysqlSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	db      *sql.DB
+	conn    *sql.Conn // session variables only apply to one connection
+	method  string
+	columns []string
+	values  []int // how each column is sent
+	count   int64
+
+	// LOAD DATA
+	pipe   *io.PipeWriter
+	buffer *bufio.Writer
+	loaded chan error
+
+	// INSERT
+	batch [][]interface{}
+}
+
+func NewMysqlSink(name string, cfg config.Config) *MysqlSink {
+	target := cfg.GetMysqlTarget()
+	db, err := sql.Open("mysql", target.GetDsn())
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not open the target MySQL database")
+	}
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not connect to the target MySQL database")
+	}
+
+	batchSize := target.GetBatchSize()
+	sink := &MysqlSink{
+		Cfg:      cfg,
+		db:       db,
+		conn:     conn,
+		method:   target.GetMethod(),
+		SinkCore: NewSinkCore(name, batchSize),
+	}
+	schema := TableSchema(cfg, name)
+	for _, f := range schema.Fields {
+		sink.columns = append(sink.columns, quoteMysqlIdent(f.Name))
+		sink.values = append(sink.values, mysqlValues(f))
+	}
+	if len(sink.columns) > 0 && batchSize*len(sink.columns) > MysqlMaxPlaceholders {
+		sink.BufferSize = MysqlMaxPlaceholders / len(sink.columns)
+	}
+
+	if err := sink.prepare(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Fatal("Could not prepare the target MySQL table")
+	}
+	if sink.method == config.MysqlLoad {
+		sink.startLoad()
+	}
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for writing
+// the exported table to the target server.
+func (s *MysqlSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		if s.pipe != nil {
+			if s.Err() == nil {
+				s.Fail(s.buffer.Flush())
+			}
+			s.pipe.CloseWithError(s.Err())
+		}
+		wg.Done()
+		log.Debug("Channel closed, table should be fully copied")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		values, err := rowValues(msg)
+		if err == nil {
+			err = s.encode(values)
+		}
+		if err == nil {
+			if s.method == config.MysqlLoad {
+				_, err = s.buffer.WriteString(loadDataLine(values))
+			} else {
+				err = s.insert(values)
+			}
+		}
+		if err != nil {
+			s.Fail(err)
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not copy row to the target MySQL table")
+			continue
+		}
+		s.count++
+	}
+}
+
+// Wait for the load to finish and turn the keys back on
+func (s *MysqlSink) ReadFinished() {
+	if s.method == config.MysqlLoad {
+		err := <-s.loaded
+		mysql.DeregisterReaderHandler(s.readerName())
+		s.Fail(err)
+	} else if s.Err() == nil {
+		s.Fail(s.flush())
+	}
+	if s.Cfg.GetMysqlTarget().IsDisableKeys() {
+		s.exec(fmt.Sprintf("ALTER TABLE %s ENABLE KEYS", quoteMysqlIdent(s.Name)))
+	}
+
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("Copy to the target MySQL table failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"rows":      s.count,
+		"method":    s.method,
+	}).Info("Copied to the target MySQL table")
+}
+
+func (s *MysqlSink) Close() {
+	s.SinkCore.Close()
+	s.conn.Close()
+	s.db.Close()
+	s.batch = nil
+}
+
+// Create, recreate or truncate the target table
+// and switch off key checks when asked to
+func (s *MysqlSink) prepare() error {
+	target := s.Cfg.GetMysqlTarget()
+	table := quoteMysqlIdent(s.Name)
+
+	create, err := mysqlutils.CreateStatement(s.Cfg.GetConn(), s.Name)
+	if err != nil {
+		return err
+	}
+	var statements []string
+	if target.IsDisableKeys() {
+		statements = append(statements, "SET unique_checks = 0", "SET foreign_key_checks = 0")
+	}
+	if target.IsRecreate() {
+		statements = append(statements, "DROP TABLE IF EXISTS "+table, create)
+	} else {
+		statements = append(statements, strings.Replace(create, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1))
+		if target.IsTruncate() {
+			statements = append(statements, "TRUNCATE TABLE "+table)
+		}
+	}
+	if target.IsDisableKeys() {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DISABLE KEYS", table))
+	}
+
+	for _, stmt := range statements {
+		if err := s.exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run LOAD DATA in the background reading from a pipe that Write fills
+func (s *MysqlSink) startLoad() {
+	reader, writer := io.Pipe()
+	s.pipe = writer
+	s.buffer = bufio.NewWriter(writer)
+	s.loaded = make(chan error, 1)
+	mysql.RegisterReaderHandler(s.readerName(), func() io.Reader { return reader })
+
+	// the file is utf8, binary values go through variables as hex
+	columns := make([]string, len(s.columns))
+	var set []string
+	for i, col := range s.columns {
+		columns[i] = col
+		switch s.values[i] {
+		case mysqlBinary, mysqlGeometry:
+			columns[i] = fmt.Sprintf("@v%d", i)
+			set = append(set, fmt.Sprintf("%s = UNHEX(@v%d)", col, i))
+		case mysqlBits:
+			// a string would be stored as its bytes, not its number
+			columns[i] = fmt.Sprintf("@v%d", i)
+			set = append(set, fmt.Sprintf("%s = CAST(@v%d AS UNSIGNED)", col, i))
+		}
+	}
+	query := fmt.Sprintf(
+		`LOAD DATA LOCAL INFILE 'Reader::%s' INTO TABLE %s CHARACTER SET utf8 `+
+			`FIELDS TERMINATED BY ',' OPTIONALLY ENCLOSED BY '"' ESCAPED BY '\\' `+
+			`LINES TERMINATED BY '\n' (%s)`,
+		s.readerName(), quoteMysqlIdent(s.Name), strings.Join(columns, ", "),
+	)
+	if len(set) > 0 {
+		query += " SET " + strings.Join(set, ", ")
+	}
+	go func() {
+		err := s.exec(query)
+		if err != nil {
+			reader.CloseWithError(err) // unblock the writer side of the pipe
+		}
+		s.loaded <- err
+	}()
+}
+
+// How the values of a column are sent
+func mysqlValues(f mysqlutils.Field) int {
+	switch base := f.ColumnType().Base; {
+	case f.IsSpatial():
+		return mysqlGeometry
+	case isBinaryType(base):
+		return mysqlBinary
+	case base == "bit":
+		return mysqlBits
+	}
+	return mysqlText
+}
+
+// Encode the values of the binary, spatial and BIT columns:
+// text for LOAD DATA, bytes and integers for INSERT
+func (s *MysqlSink) encode(values []interface{}) error {
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok || i >= len(s.values) || s.values[i] == mysqlText {
+			continue
+		}
+		var data []byte
+		switch s.values[i] {
+		case mysqlBinary:
+			data = []byte(str)
+		case mysqlGeometry:
+			srid, g, err := mysqlutils.ParseEWKT(str)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", s.columns[i], err)
+			}
+			data = mysqlutils.MysqlGeometry(srid, g)
+		case mysqlBits:
+			n, err := mysqlutils.ParseBits(str)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", s.columns[i], err)
+			}
+			values[i] = n
+			if s.method == config.MysqlLoad {
+				values[i] = strconv.FormatUint(n, 10)
+			}
+			continue
+		}
+		values[i] = data
+		if s.method == config.MysqlLoad {
+			values[i] = hex.EncodeToString(data)
+		}
+	}
+	return nil
+}
+
+// Queue a row and send the batch once it is full
+func (s *MysqlSink) insert(values []interface{}) error {
+	s.batch = append(s.batch, values)
+	if len(s.batch) >= s.BufferSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Send the queued rows as one multi-row INSERT
+func (s *MysqlSink) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	row := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(s.columns)), ", ") + ")"
+	rows := make([]string, len(s.batch))
+	args := make([]interface{}, 0, len(s.batch)*len(s.columns))
+	for i, values := range s.batch {
+		rows[i] = row
+		args = append(args, values...)
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		quoteMysqlIdent(s.Name), strings.Join(s.columns, ", "), strings.Join(rows, ", "))
+	_, err := s.conn.ExecContext(context.Background(), query, args...)
+	s.batch = s.batch[:0]
+	return err
+}
+
+func (s *MysqlSink) exec(query string) error {
+	_, err := s.conn.ExecContext(context.Background(), query)
+	return err
+}
+
+// Name the LOAD DATA reader is registered under, unique per table
+func (s *MysqlSink) readerName() string {
+	return "skrape_" + s.Cfg.GetConn().Database + "_" + s.Name
+}
+
+// Encode a row for LOAD DATA: NULL as \N and every
+// other value enclosed in quotes with backslash escapes
+func loadDataLine(values []interface{}) string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			fields[i] = `\N`
+			continue
+		}
+		fields[i] = `"` + loadDataEscaper.Replace(v.(string)) + `"`
+	}
+	return strings.Join(fields, ",") + "\n"
+}
+
+var loadDataEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x00", `\0`,
+)
+
+func quoteMysqlIdent(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
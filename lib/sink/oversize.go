@@ -0,0 +1,177 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/skrape/skrapes3"
+	"github.com/MasteryConnect/skrape/lib/structs"
+	"github.com/apex/log"
+)
+
+const (
+	KplOverhead      = 64  // magic, checksum and protobuf framing of a lone aggregated row
+	ChunkOverhead    = 512 // room for the chunk metadata around the data
+	TruncateSlack    = 64  // extra bytes cut to absorb JSON escaping
+	TruncatedField   = "_skrape_truncated"
+	ChunkField       = "_skrape_chunk"
+	S3PointerField   = "_skrape_s3"
+	OversizeS3Prefix = "oversize"
+)
+
+// Metadata a consumer reassembles a split row from. The
+// base64 data of every chunk, ordered by index, joins up
+// into the JSON of the original row.
+type chunkMeta struct {
+	ID    string `json:"id"`
+	Table string `json:"table"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+}
+
+type s3Pointer struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	Size   int    `json:"size"`
+}
+
+// Largest payload a record with this partition key can carry
+func (ks *KinesisSink) maxPayload(key string) int {
+	n := KinesisMaxRecordBytes - len(key)
+	if ks.aggregator != nil {
+		n -= KplOverhead + len(key) // the key is also in the key table
+	}
+	return n
+}
+
+// Make a row fit into Kinesis records following the oversize
+// policy. Rows that fit come back as they are, a nil result
+// means the row went to the dead letter file.
+func (ks *KinesisSink) fit(record structs.Record, key string, data []byte) ([][]byte, error) {
+	limit := ks.maxPayload(key)
+	if len(data) <= limit {
+		return [][]byte{data}, nil
+	}
+	cause := fmt.Errorf("row of %d bytes is over the Kinesis record limit of %d", len(data), limit)
+	log.WithFields(log.Fields{
+		"TableName": ks.Name,
+		"key":       key,
+		"size":      len(data),
+		"policy":    ks.oversize,
+	}).Warn("Oversized row")
+
+	switch ks.oversize {
+	case config.OversizeSplit:
+		return ks.split(data, limit)
+	case config.OversizeS3:
+		pointer, err := ks.offload(record, data)
+		return [][]byte{pointer}, err
+	case config.OversizeTruncate:
+		truncated, err := ks.truncate(record, limit)
+		if err == nil {
+			return [][]byte{truncated}, nil
+		}
+		cause = err
+		fallthrough // nothing left to cut, keep the row
+	case config.OversizeDeadLetter:
+		return nil, ks.rejects.Reject(StageOversize, "kinesis", key, data, cause)
+	}
+	return nil, cause
+}
+
+// Cut the row into chunks that each fit into a record. They
+// share the partition key so they land on the same shard.
+func (ks *KinesisSink) split(data []byte, limit int) ([][]byte, error) {
+	ks.oversizeSeq++
+	meta := chunkMeta{
+		ID:    fmt.Sprintf("%s:%s:%d", ks.Cfg.GetRunID(), ks.Name, ks.oversizeSeq),
+		Table: ks.Name,
+	}
+	size := (limit - ChunkOverhead - len(meta.ID)) / 4 * 3 // raw bytes per chunk, before base64
+	meta.Count = (len(data) + size - 1) / size
+
+	var chunks [][]byte
+	for start := 0; start < len(data); start += size {
+		end := start + size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk, err := json.Marshal(map[string]interface{}{
+			ChunkField: meta,
+			"data":     data[start:end], // []byte marshals as base64
+		})
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+		meta.Index++
+	}
+	return chunks, nil
+}
+
+// Upload the row to S3 and return a record pointing at it,
+// carrying the primary key so consumers can still route it.
+// Objects are named by run, table and sequence as partition
+// keys are shared by many rows.
+func (ks *KinesisSink) offload(record structs.Record, data []byte) ([]byte, error) {
+	ks.oversizeSeq++
+	s3cfg := ks.Cfg.GetS3()
+	file := fmt.Sprintf("%s-%s-%d.json", ks.Cfg.GetRunID(), ks.Name, ks.oversizeSeq)
+	objectKey := ks.Cfg.GetS3Key(OversizeS3Prefix, ks.Name, file)
+	_, err := skrapes3.StreamUpload(
+		bytes.NewReader(data),
+		s3cfg.GetBucket(),
+		objectKey,
+		s3cfg.GetPartSize(),
+		s3cfg.GetConcurrency(),
+		s3cfg.GetMaxRetries(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pointer := structs.Record{
+		S3PointerField: s3Pointer{Bucket: s3cfg.GetBucket(), Key: objectKey, Size: len(data)},
+		"deltatype":    record["deltatype"],
+	}
+	for _, k := range ks.schema.PrimaryKey() {
+		pointer[k] = record[k]
+	}
+	return pointer.Json()
+}
+
+// Shorten the truncate columns, in the order given, until the
+// row fits. The record lists the columns that were cut.
+func (ks *KinesisSink) truncate(record structs.Record, limit int) ([]byte, error) {
+	var cut []string
+	data, err := record.Json()
+	for _, col := range ks.truncateCols {
+		value, ok := record[col].(string)
+		for ok && err == nil && len(data) > limit && value != "" {
+			n := len(value) - (len(data) - limit) - TruncateSlack
+			if n < 0 {
+				n = 0
+			}
+			for n > 0 && !utf8.RuneStart(value[n]) {
+				n-- // don't cut a character in half
+			}
+			value = value[:n]
+			record[col] = value
+			if len(cut) == 0 || cut[len(cut)-1] != col {
+				cut = append(cut, col)
+			}
+			record[TruncatedField] = cut
+			data, err = record.Json()
+		}
+		if err != nil || len(data) <= limit {
+			break
+		}
+	}
+	if err == nil && len(data) > limit {
+		err = fmt.Errorf("row is still %d bytes after truncating %v", len(data), ks.truncateCols)
+	}
+	return data, err
+}
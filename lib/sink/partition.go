@@ -0,0 +1,97 @@
+package sink
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/structs"
+)
+
+const KinesisMaxKeyLength = 256
+
+// {column} placeholders of a partition key expression
+var partitionColumn = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// partitioner derives the Kinesis partition key of each
+// record from the strategy chosen for the table
+type partitioner struct {
+	strategy string
+	expr     string
+	schema   *mysqlutils.Schema
+	shards   []string // starting hash key of each open shard, for round-robin
+	next     int
+}
+
+func newPartitioner(spec string, schema *mysqlutils.Schema) (*partitioner, error) {
+	strategy, arg, err := config.ParsePartitionKey(spec)
+	if err != nil {
+		return nil, err
+	}
+	p := &partitioner{strategy: strategy, expr: arg, schema: schema}
+	switch strategy {
+	case config.PartitionColumn:
+		p.strategy, p.expr = config.PartitionExpr, "{"+arg+"}"
+	case config.PartitionPrimary:
+		if len(schema.PrimaryKey()) == 0 {
+			// one key for every row would put the table on a single shard
+			p.strategy = config.PartitionRandom
+		}
+	}
+	if p.strategy == config.PartitionExpr {
+		columns := map[string]bool{}
+		for _, f := range schema.Fields {
+			columns[f.Name] = true
+		}
+		for _, m := range partitionColumn.FindAllStringSubmatch(p.expr, -1) {
+			if !columns[m[1]] {
+				return nil, fmt.Errorf("partition key column %q is not in the table", m[1])
+			}
+		}
+	}
+	return p, nil
+}
+
+// Partition key of a record, hashed when too long for Kinesis
+func (p *partitioner) key(record structs.Record) string {
+	var key string
+	switch p.strategy {
+	case config.PartitionPrimary:
+		key = recordKey(p.schema, record)
+	case config.PartitionExpr:
+		key = partitionColumn.ReplaceAllStringFunc(p.expr, func(m string) string {
+			return fmt.Sprintf("%v", record[m[1:len(m)-1]])
+		})
+	case config.PartitionRoundRobin:
+		key = strconv.Itoa(p.next)
+	default:
+		key = strconv.FormatInt(rand.Int63(), 36)
+	}
+	if key == "" {
+		// Kinesis rejects empty keys, and one fixed key
+		// would put every keyless row on the same shard
+		key = strconv.FormatInt(rand.Int63(), 36)
+	}
+	if len(key) > KinesisMaxKeyLength {
+		sum := md5.Sum([]byte(key))
+		key = hex.EncodeToString(sum[:])
+	}
+	return key
+}
+
+// Explicit hash key of the next record put, sending
+// records to the open shards in turn. Nil for every
+// strategy other than round-robin.
+func (p *partitioner) hashKey() *string {
+	if p.strategy != config.PartitionRoundRobin || len(p.shards) == 0 {
+		return nil
+	}
+	start := p.shards[p.next%len(p.shards)]
+	p.next++
+	return &start
+}
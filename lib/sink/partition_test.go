@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/structs"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var partitionSchema = &mysqlutils.Schema{Fields: []mysqlutils.Field{
+	{Name: "tenant", Type: "int(11)", Key: "PRI"},
+	{Name: "id", Type: "int(11)", Key: "PRI"},
+	{Name: "name", Type: "varchar(255)"},
+}}
+
+var keylessSchema = &mysqlutils.Schema{Fields: []mysqlutils.Field{
+	{Name: "name", Type: "varchar(255)"},
+}}
+
+func TestPartitionerKey(t *testing.T) {
+	long := strings.Repeat("n", KinesisMaxKeyLength+1)
+	tests := []struct {
+		name   string
+		spec   string
+		schema *mysqlutils.Schema
+		record structs.Record
+		want   string
+	}{
+		{"primary key", "primary", partitionSchema, structs.Record{"tenant": 7, "id": 42, "name": "a"}, "7-42"},
+		{"column", "column:name", partitionSchema, structs.Record{"tenant": 7, "id": 42, "name": "a"}, "a"},
+		{"expression", "expr:{tenant}/{name}", partitionSchema, structs.Record{"tenant": 7, "id": 42, "name": "a"}, "7/a"},
+		{"long key hashed", "column:name", partitionSchema, structs.Record{"name": long}, "4f5d15f5540ebab4094c86ef11584bf4"},
+		{"key of exactly the limit", "column:name", partitionSchema, structs.Record{"name": long[1:]}, long[1:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPartitioner(tt.spec, tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.key(tt.record); got != tt.want {
+				t.Errorf("key = %q, want %q", got, tt.want)
+			}
+			if p.hashKey() != nil {
+				t.Errorf("%s has an explicit hash key", tt.spec)
+			}
+		})
+	}
+}
+
+// Strategies that can't derive a key from the row spread them randomly
+func TestPartitionerRandomKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		schema *mysqlutils.Schema
+		record structs.Record
+	}{
+		{"random", "random", partitionSchema, structs.Record{"tenant": 7, "id": 42}},
+		{"primary key without one", "primary", keylessSchema, structs.Record{"name": "a"}},
+		{"empty column", "column:name", keylessSchema, structs.Record{"name": ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPartitioner(tt.spec, tt.schema)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen := map[string]bool{}
+			for i := 0; i < 20; i++ {
+				key := p.key(tt.record)
+				if key == "" || len(key) > KinesisMaxKeyLength {
+					t.Fatalf("invalid key %q", key)
+				}
+				seen[key] = true
+			}
+			if len(seen) < 2 {
+				t.Errorf("20 rows all got key %v", seen)
+			}
+		})
+	}
+}
+
+func TestPartitionerErrors(t *testing.T) {
+	for _, spec := range []string{"column:missing", "expr:{tenant}-{missing}", "column:", "random:x", "hash"} {
+		if _, err := newPartitioner(spec, partitionSchema); err == nil {
+			t.Errorf("newPartitioner(%q) did not fail", spec)
+		}
+	}
+}
+
+// Round-robin sends the records to the open shards in turn
+func TestPartitionerHashKey(t *testing.T) {
+	p, err := newPartitioner(config.PartitionRoundRobin, partitionSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.hashKey() != nil {
+		t.Error("hash key before the shards are known")
+	}
+	p.shards = []string{"0", "113427455640312821154458202477256070485", "226854911280625642308916404954512140970"}
+	for i := 0; i < 7; i++ {
+		want := p.shards[i%len(p.shards)]
+		if got := aws.StringValue(p.hashKey()); got != want {
+			t.Errorf("record %d: hash key %s, want %s", i, got, want)
+		}
+	}
+	if got, want := p.key(structs.Record{}), "7"; got != want {
+		t.Errorf("key after 7 records = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,294 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+	"github.com/lib/pq"
+)
+
+// PostgresSink streams rows into a PostgreSQL table with COPY
+type PostgresSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	schema    *mysqlutils.Schema
+	db        *sql.DB
+	txn       *sql.Tx
+	stmt      *sql.Stmt
+	target    string // table the rows are copied into
+	binary    []bool // columns loaded as bytea
+	bits      []int  // width of BIT columns, 0 for the others
+	dates     []bool // columns where MySQL zero dates become NULL
+	count     int64
+	mode      string
+	namespace string // PostgreSQL schema of the target
+}
+
+func NewPostgresSink(name string, cfg config.Config) *PostgresSink {
+	pg := cfg.GetPostgres()
+	db, err := sql.Open("postgres", pg.GetURL())
+	if err == nil {
+		err = db.Ping()
+	}
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not connect to PostgreSQL")
+	}
+
+	sink := &PostgresSink{
+		Cfg:       cfg,
+		db:        db,
+		target:    name,
+		mode:      pg.GetMode(),
+		namespace: pg.GetSchema(),
+		SinkCore:  NewSinkCore(name, 0),
+	}
+	sink.schema = TableSchema(cfg, name)
+	for _, f := range sink.schema.Fields {
+		ct := f.ColumnType()
+		sink.binary = append(sink.binary, isBinaryType(ct.Base))
+		width := 0
+		if ct.Base == "bit" {
+			width = ct.BitWidth()
+		}
+		sink.bits = append(sink.bits, width)
+		sink.dates = append(sink.dates, ct.Base == "date" || ct.Base == "datetime" || ct.Base == "timestamp")
+	}
+
+	if err := sink.prepare(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Fatal("Could not prepare PostgreSQL table")
+	}
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for copying
+// the exported table into PostgreSQL.
+func (s *PostgresSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully copied")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		values, err := rowValues(msg)
+		if err == nil {
+			err = s.convert(values)
+		}
+		if err == nil {
+			_, err = s.stmt.Exec(values...)
+		}
+		if err != nil {
+			s.Fail(err)
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not copy row to PostgreSQL")
+			continue
+		}
+		s.count++
+	}
+}
+
+// Flush the COPY, swap in the staging table when
+// loading through one and commit the transaction
+func (s *PostgresSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.finish())
+	}
+	if err := s.Err(); err != nil {
+		s.txn.Rollback()
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("PostgreSQL load rolled back")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"rows":      s.count,
+		"mode":      s.mode,
+	}).Info("Loaded into PostgreSQL")
+}
+
+func (s *PostgresSink) Close() {
+	s.SinkCore.Close()
+	s.db.Close()
+	s.schema = nil
+}
+
+// Create or validate the target table, start the
+// transaction and open the COPY for the load mode
+func (s *PostgresSink) prepare() (err error) {
+	exists, err := s.validate()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if err = s.exec(s.createTable(s.Name)); err != nil {
+			return err
+		}
+	}
+
+	s.txn, err = s.db.Begin()
+	if err != nil {
+		return err
+	}
+	// mysqldump writes timestamps in UTC
+	if _, err = s.txn.Exec("SET LOCAL TIME ZONE 'UTC'"); err != nil {
+		return err
+	}
+
+	switch s.mode {
+	case config.PgTruncate:
+		_, err = s.txn.Exec("TRUNCATE TABLE " + s.ident(s.Name))
+	case config.PgStaging:
+		s.target = s.Name + "_skrape_staging"
+		_, err = s.txn.Exec(fmt.Sprintf(
+			"DROP TABLE IF EXISTS %s; CREATE TABLE %s (LIKE %s INCLUDING ALL)",
+			s.ident(s.target), s.ident(s.target), s.ident(s.Name),
+		))
+	case config.PgAppend:
+	default:
+		err = fmt.Errorf("unknown PostgreSQL load mode %q", s.mode)
+	}
+	if err != nil {
+		return err
+	}
+
+	var cols []string
+	for _, f := range s.schema.Fields {
+		cols = append(cols, f.Name)
+	}
+	s.stmt, err = s.txn.Prepare(pq.CopyInSchema(s.namespace, s.target, cols...))
+	return err
+}
+
+// Check the target table has every exported column.
+// Returns false when the table doesn't exist yet.
+func (s *PostgresSink) validate() (bool, error) {
+	rows, err := s.db.Query(
+		"SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2",
+		s.namespace, s.Name,
+	)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	columns := map[string]bool{}
+	for rows.Next() {
+		var col string
+		if err := rows.Scan(&col); err != nil {
+			return false, err
+		}
+		columns[col] = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if len(columns) == 0 {
+		return false, nil
+	}
+
+	var missing []string
+	for _, f := range s.schema.Fields {
+		if !columns[f.Name] {
+			missing = append(missing, f.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return true, fmt.Errorf("table %s is missing columns: %s", s.ident(s.Name), strings.Join(missing, ", "))
+	}
+	return true, nil
+}
+
+// End the COPY and commit. A staging table
+// replaces the target inside the same transaction.
+func (s *PostgresSink) finish() error {
+	if _, err := s.stmt.Exec(); err != nil {
+		return err
+	}
+	if err := s.stmt.Close(); err != nil {
+		return err
+	}
+	if s.mode == config.PgStaging {
+		old := s.Name + "_skrape_old"
+		_, err := s.txn.Exec(fmt.Sprintf(
+			"ALTER TABLE %s RENAME TO %s; ALTER TABLE %s RENAME TO %s; DROP TABLE %s",
+			s.ident(s.Name), pq.QuoteIdentifier(old),
+			s.ident(s.target), pq.QuoteIdentifier(s.Name),
+			s.ident(old),
+		))
+		if err != nil {
+			return err
+		}
+	}
+	return s.txn.Commit()
+}
+
+// Adjust values PostgreSQL reads differently from MySQL
+func (s *PostgresSink) convert(values []interface{}) error {
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok || i >= len(s.binary) {
+			continue
+		}
+		switch {
+		case s.binary[i]:
+			values[i] = []byte(str) // sent as bytea instead of text
+		case s.bits[i] > 0:
+			// b'0101' literals become the boolean or bigint of the DDL
+			n, err := mysqlutils.ParseBits(str)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", s.schema.Fields[i].Name, err)
+			}
+			if s.bits[i] == 1 {
+				values[i] = n != 0
+			} else {
+				values[i] = int64(n)
+			}
+		case s.dates[i] && strings.HasPrefix(str, "0000-00-00"):
+			values[i] = nil // zero dates don't exist in PostgreSQL
+		}
+	}
+	return nil
+}
+
+func (s *PostgresSink) createTable(table string) string {
+	ddl, err := s.schema.CreateTable(mysqlutils.Postgres, s.ident(table))
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not generate PostgreSQL DDL for: " + s.Name)
+	}
+	return ddl
+}
+
+func (s *PostgresSink) exec(query string) error {
+	_, err := s.db.Exec(query)
+	return err
+}
+
+// Schema qualified identifier for a table
+func (s *PostgresSink) ident(table string) string {
+	return pq.QuoteIdentifier(s.namespace) + "." + pq.QuoteIdentifier(table)
+}
+
+func isBinaryType(base string) bool {
+	switch base {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package sink
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens a
+// second holding up to one second worth. A take larger than
+// what is available goes into debt and waits it off, so
+// requests bigger than the bucket still get through.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Take n tokens, sleeping until the bucket can pay for them
+func (r *rateLimiter) wait(n int) {
+	r.mu.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.rate {
+		r.tokens = r.rate
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	var pause time.Duration
+	if r.tokens < 0 {
+		pause = time.Duration(-r.tokens / r.rate * float64(time.Second))
+	}
+	r.mu.Unlock()
+	time.Sleep(pause)
+}
@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/structs"
+)
+
+// Build a typed record from a csv row using the table schema
+// and its type mapping. Shared by every sink that emits rows
+// as JSON documents.
+func newRecord(schema *mysqlutils.Schema, msg string) (structs.Record, error) {
+	values, err := split(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != len(schema.Fields) {
+		return nil, fmt.Errorf("row has %d values but the table has %d columns", len(values), len(schema.Fields))
+	}
+	record := structs.Record{}
+
+	for i, field := range schema.Fields {
+		v, err := schema.Value(i, values[i])
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", field.Name, err)
+		}
+		record[field.Name] = v
+	}
+	return record, nil
+}
+
+var (
+	schemasMu    sync.Mutex
+	tableSchemas = map[string]*mysqlutils.Schema{}
+)
+
+// Schema of a table set up with the type mapping of the run,
+// loaded once and shared by the extract and its sinks
+func TableSchema(cfg config.Config, name string) *mysqlutils.Schema {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	if schema, ok := tableSchemas[name]; ok {
+		return schema
+	}
+	schema, _ := mysqlutils.TableSchema(cfg.GetConn(), name)
+	schema.SetTypes(cfg.GetTypes().TypeMap())
+	tableSchemas[name] = schema
+	return schema
+}
+
+// Forget the schema of a finished table
+func ReleaseTableSchema(name string) {
+	schemasMu.Lock()
+	defer schemasMu.Unlock()
+	delete(tableSchemas, name)
+}
+
+// Values of the primary key columns joined into one string,
+// the id column when the table has no primary key and empty
+// when it has neither
+func recordKey(schema *mysqlutils.Schema, record structs.Record) string {
+	keys := schema.PrimaryKey()
+	if len(keys) == 0 {
+		if id, ok := record["id"]; ok && id != nil {
+			return fmt.Sprintf("%v", id)
+		}
+		return ""
+	}
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = fmt.Sprintf("%v", record[k])
+	}
+	return strings.Join(values, "-")
+}
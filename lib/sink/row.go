@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"encoding/csv"
+	"strings"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/utility"
+)
+
+// Split a csv row from the data channel into its raw values
+func split(msg string) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(msg))
+
+	return r.Read()
+}
+
+// Split a csv row into the values it holds. NULL becomes nil
+// and the mysqldump escape sequences in strings are replaced.
+func rowValues(msg string) ([]interface{}, error) {
+	raw, err := split(msg)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(raw))
+	for i, val := range raw {
+		if val == "NULL" {
+			continue
+		}
+		values[i] = utility.MysqlUnescape(val)
+	}
+	return values, nil
+}
+
+// Encode a csv row in one of the text formats without the
+// line ending. The schema is only needed for JSON lines.
+func encodeRow(format string, schema *mysqlutils.Schema, msg string) ([]byte, error) {
+	if format != config.FormatJsonl {
+		return []byte(msg), nil
+	}
+	record, err := newRecord(schema, msg)
+	if err != nil {
+		return nil, err
+	}
+	return record.Json()
+}
@@ -1,9 +1,10 @@
 package sink
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"os"
+	"strings"
 
 	"github.com/MasteryConnect/skrape/lib/config"
 	"github.com/MasteryConnect/skrape/lib/mysqlutils"
@@ -28,10 +29,18 @@ func (s *S3Sink) ReadFinished() {
 	// Flush and close file
 	s.SinkCore.Close()
 	s.File.Close()
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("Skipping upload of failed table")
+		return
+	}
 	// Upload file
 	log.WithField("TableName", s.Name).Info("Uploading file")
-	skrapes3.Gzipload(s.Name, s.Path)
+	size := skrapes3.Gzipload(s.Name, s.Path, s.Cfg.GetS3().GetBucket(), dataKey(s.Cfg, s.Name))
 	s.Schema()
+	uploadManifest(s.Cfg, s.Name, size)
 }
 
 func (s *S3Sink) Close() {
@@ -40,29 +49,60 @@ func (s *S3Sink) Close() {
 
 // Export a table schema to S3
 func (s *S3Sink) Schema() {
-	schema, paths := mysqlutils.TableSchema(s.Cfg.GetConn(), s.Name)
+	uploadSchema(s.Cfg, s.Name)
+}
+
+// Upload the schema and JSONPaths files for a table.
+// Both are encoded in memory, nothing touches the disk.
+func uploadSchema(cfg config.Config, name string) {
+	schema, paths := mysqlutils.TableSchema(cfg.GetConn(), name)
+
+	schemaname := name + ".json"
+	pathsname := name + "_paths.json"
+
+	var schemabuf, pathsbuf bytes.Buffer
+	json.NewEncoder(&schemabuf).Encode(schema)
+	json.NewEncoder(&pathsbuf).Encode(paths)
 
-	schemaname := s.Name + ".json"
-	pathsname := s.Name + "_paths.json"
-	schemafile, _ := os.Create(s.Path + "/" + schemaname)
-	pathsfile, _ := os.Create(s.Path + "/" + pathsname)
+	bucket := cfg.GetS3().GetBucket()
+	skrapes3.S3Upload(bytes.NewReader(schemabuf.Bytes()), bucket, cfg.GetS3Key("schemas", name, schemaname))
+	log.Info("Schema uploaded for: " + name)
 
-	defer schemafile.Close()
-	defer pathsfile.Close()
+	skrapes3.S3Upload(bytes.NewReader(pathsbuf.Bytes()), bucket, cfg.GetS3Key("paths", name, pathsname))
+	log.Info("JSONPaths file uploaded for: " + name)
 
-	encoder := json.NewEncoder(schemafile)
-	encoder.Encode(schema)
-	schemafile.Sync()
+	for _, dialect := range cfg.GetS3().GetDDL() {
+		ddl, err := schema.DDL(dialect, name)
+		if err != nil {
+			log.WithField("error", err).Warn("Could not generate DDL for: " + name)
+			continue
+		}
+		ddlname := fmt.Sprintf("%s.%s.sql", name, dialect)
+		skrapes3.S3Upload(strings.NewReader(ddl), bucket, cfg.GetS3Key("ddl", name, ddlname))
+		log.Info(dialect + " DDL uploaded for: " + name)
+	}
+}
 
-	encoder = json.NewEncoder(pathsfile)
-	encoder.Encode(paths)
-	pathsfile.Sync()
+// Key of a table's gzipped data file
+func dataKey(cfg config.Config, name string) string {
+	return cfg.GetS3Key("data", name, name+".csv.gz")
+}
+
+// Upload a Redshift manifest for a table's data file and add the
+// file to the run manifest. Only called once the data file is fully
+// uploaded so loaders never see a partial or stale object.
+func uploadManifest(cfg config.Config, name string, size int64) {
+	s3cfg := cfg.GetS3()
+	if !s3cfg.IsManifest() {
+		return
+	}
+	bucket := s3cfg.GetBucket()
+	key := dataKey(cfg, name)
 
-	skrapes3.S3Upload(schemafile, os.Getenv("S3_BUCKET"), fmt.Sprintf("%s/%s/schemas/%s", os.Getenv("S3_KEY"), skrapes3.S3DateKey(), schemaname))
-	os.Remove(s.Path + "/" + schemaname)
-	log.Info("Schema uploaded for: " + s.Name)
+	manifest := skrapes3.NewManifest()
+	manifest.Add(bucket, key, size)
+	manifest.Upload(bucket, cfg.GetS3Key("manifests", name, name+".manifest"))
+	log.Info("Manifest uploaded for: " + name)
 
-	skrapes3.S3Upload(pathsfile, os.Getenv("S3_BUCKET"), fmt.Sprintf("%s/%s/paths/%s", os.Getenv("S3_KEY"), skrapes3.S3DateKey(), pathsname))
-	os.Remove(s.Path + "/" + pathsname)
-	log.Info("JSONPaths file uploaded for: " + s.Name)
+	skrapes3.RunManifest.Add(bucket, key, size)
 }
@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/skrape/skrapes3"
+	"github.com/apex/log"
+)
+
+// Size of the buffer in front of the gzip writer. Kept small on
+// purpose, the multipart upload already buffers whole parts.
+const StreamBufferSize = 1048576 // 1MB in bytes
+
+// S3StreamSink gzips rows as they arrive and streams them
+// straight into a multipart upload without a local csv file.
+type S3StreamSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	Buffer  *bufio.Writer
+	gzip    *gzip.Writer
+	pipe    *io.PipeWriter
+	counter *skrapes3.CountingWriter
+	done    chan error
+}
+
+func NewS3StreamSink(name string, cfg config.Config) *S3StreamSink {
+	s3cfg := cfg.GetS3()
+	reader, writer := io.Pipe()
+	counter := skrapes3.NewCountingWriter(writer)
+	gw := gzip.NewWriter(counter)
+
+	sink := &S3StreamSink{
+		Cfg:      cfg,
+		Buffer:   bufio.NewWriterSize(gw, StreamBufferSize),
+		gzip:     gw,
+		pipe:     writer,
+		counter:  counter,
+		done:     make(chan error, 1),
+		SinkCore: NewSinkCore(name, StreamBufferSize),
+	}
+
+	// The upload consumes the pipe while Write fills it
+	go func() {
+		result, err := skrapes3.StreamUpload(
+			reader,
+			s3cfg.GetBucket(),
+			dataKey(cfg, name),
+			s3cfg.GetPartSize(),
+			s3cfg.GetConcurrency(),
+			s3cfg.GetMaxRetries(),
+		)
+		if err != nil {
+			// unblock the writer side of the pipe
+			reader.CloseWithError(err)
+		} else {
+			log.WithField("location", result.Location).Info("Successfully uploaded to")
+		}
+		sink.done <- err
+	}()
+
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for pushing
+// the exported table into the upload stream.
+func (s *S3StreamSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		if s.Err() == nil {
+			s.Fail(s.Buffer.Flush())
+		}
+		if s.Err() == nil {
+			s.Fail(s.gzip.Close())
+		}
+		// a failed table aborts the upload instead of completing it
+		s.pipe.CloseWithError(s.Err())
+		wg.Done()
+		log.Debug("Channel closed, table should be fully streamed")
+	}()
+
+	for str := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		_, err := s.Buffer.WriteString(fmt.Sprintf("%s\n", str))
+		if err != nil {
+			s.Fail(err)
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+			}).Error("Could not write to the upload stream")
+		}
+	}
+}
+
+// Wait for the upload to complete then export the schema
+func (s *S3StreamSink) ReadFinished() {
+	log.WithField("TableName", s.Name).Info("Waiting for upload to complete")
+	s.Fail(<-s.done)
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("Failed to upload file.")
+		return
+	}
+	uploadSchema(s.Cfg, s.Name)
+	uploadManifest(s.Cfg, s.Name, s.counter.Count)
+}
+
+func (s *S3StreamSink) Close() {
+	s.SinkCore.Close()
+	s.Buffer = nil
+	s.gzip = nil
+	s.counter = nil
+}
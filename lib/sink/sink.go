@@ -10,12 +10,17 @@ type Sink interface {
 	Close()
 	Data(string)
 	EndOfData()
+	Fail(error)
+	Err() error
 }
 
 type SinkCore struct {
 	DataChan   chan string
 	BufferSize int
 	Name       string
+
+	mu  sync.Mutex
+	err error
 }
 
 func NewSinkCore(name string, bufferSize int) *SinkCore {
@@ -41,3 +46,25 @@ func (s *SinkCore) Data(data string) {
 func (s *SinkCore) EndOfData() {
 	close(s.DataChan)
 }
+
+// Mark the table as failed. Only the first error is kept,
+// a nil error is ignored. Once failed a sink keeps draining
+// its channel so the reader never blocks, but writes nothing
+// more and skips finishing the table in ReadFinished.
+func (s *SinkCore) Fail(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// The error the table failed with, nil while it is healthy
+func (s *SinkCore) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,214 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Every table of a run is written into the same
+// database file, shared through a single connection.
+var (
+	sqliteMu   sync.Mutex
+	sqliteDB   *sql.DB
+	sqliteRefs int
+)
+
+// SqliteSink writes a table into a SQLite database
+// file, inserting rows in large transactions.
+type SqliteSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	db     *sql.DB
+	txn    *sql.Tx
+	stmt   *sql.Stmt
+	insert string
+	binary []bool // columns stored as BLOB
+	bits   []bool // BIT columns, stored as INTEGER
+	rows   int    // rows in the open transaction
+	count  int64
+}
+
+func NewSqliteSink(name string, cfg config.Config) *SqliteSink {
+	lite := cfg.GetSqlite()
+	db, err := openSqlite(lite.GetPath())
+	if err != nil {
+		log.WithField("error", err).Fatal("Could not open the SQLite database")
+	}
+
+	sink := &SqliteSink{
+		Cfg:      cfg,
+		db:       db,
+		SinkCore: NewSinkCore(name, lite.GetBatchSize()),
+	}
+	schema := TableSchema(cfg, name)
+	var cols []string
+	for _, f := range schema.Fields {
+		cols = append(cols, mysqlutils.QuoteIdent(f.Name))
+		sink.binary = append(sink.binary, mysqlutils.SqliteType(f) == "BLOB")
+		sink.bits = append(sink.bits, f.ColumnType().Base == "bit")
+	}
+	sink.insert = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		mysqlutils.QuoteIdent(name),
+		strings.Join(cols, ", "),
+		strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "),
+	)
+
+	ddl, err := schema.DDL(mysqlutils.Sqlite, name)
+	if err == nil {
+		_, err = db.Exec("DROP TABLE IF EXISTS " + mysqlutils.QuoteIdent(name))
+	}
+	if err == nil {
+		_, err = db.Exec(ddl)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Fatal("Could not create the SQLite table")
+	}
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for writing
+// the exported table to the SQLite file.
+func (s *SqliteSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully written")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		values, err := rowValues(msg)
+		if err == nil {
+			err = s.add(values)
+		}
+		if err != nil {
+			s.Fail(err)
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not insert row into SQLite")
+			continue
+		}
+		s.count++
+	}
+}
+
+// Commit the last transaction
+func (s *SqliteSink) ReadFinished() {
+	if s.Err() == nil {
+		s.Fail(s.commit())
+	}
+	if err := s.Err(); err != nil {
+		if s.txn != nil {
+			s.txn.Rollback()
+		}
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"error":     err,
+		}).Error("SQLite export failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"rows":      s.count,
+		"file":      s.Cfg.GetSqlite().GetPath(),
+	}).Info("Written to SQLite")
+}
+
+func (s *SqliteSink) Close() {
+	s.SinkCore.Close()
+	closeSqlite()
+}
+
+// Insert a row, opening a new transaction every BufferSize rows
+func (s *SqliteSink) add(values []interface{}) error {
+	if s.txn == nil {
+		var err error
+		if s.txn, err = s.db.Begin(); err != nil {
+			return err
+		}
+		if s.stmt, err = s.txn.Prepare(s.insert); err != nil {
+			return err
+		}
+	}
+	for i, v := range values {
+		str, ok := v.(string)
+		if !ok || i >= len(s.binary) {
+			continue
+		}
+		if s.binary[i] {
+			values[i] = []byte(str)
+		} else if s.bits[i] {
+			n, err := mysqlutils.ParseBits(str) // b'0101' literals
+			if err != nil {
+				return err
+			}
+			values[i] = int64(n)
+		}
+	}
+	if _, err := s.stmt.Exec(values...); err != nil {
+		return err
+	}
+	s.rows++
+	if s.rows >= s.BufferSize {
+		return s.commit()
+	}
+	return nil
+}
+
+func (s *SqliteSink) commit() error {
+	if s.txn == nil {
+		return nil
+	}
+	s.stmt.Close()
+	err := s.txn.Commit()
+	s.txn, s.stmt, s.rows = nil, nil, 0
+	return err
+}
+
+// Open the shared database, or hand out the one already open
+func openSqlite(path string) (*sql.DB, error) {
+	sqliteMu.Lock()
+	defer sqliteMu.Unlock()
+	if sqliteDB == nil {
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			return nil, err
+		}
+		// SQLite has a single writer, tables take turns on one connection
+		db.SetMaxOpenConns(1)
+		// a snapshot is rebuilt from scratch after a crash, skip the fsyncs
+		if _, err := db.Exec("PRAGMA synchronous = OFF"); err != nil {
+			db.Close()
+			return nil, err
+		}
+		sqliteDB = db
+	}
+	sqliteRefs++
+	return sqliteDB, nil
+}
+
+// Close the shared database once the last table is done with it
+func closeSqlite() {
+	sqliteMu.Lock()
+	defer sqliteMu.Unlock()
+	sqliteRefs--
+	if sqliteRefs == 0 && sqliteDB != nil {
+		sqliteDB.Close()
+		sqliteDB = nil
+	}
+}
@@ -0,0 +1,336 @@
+package sink
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/structs"
+	"github.com/apex/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// SendMessageBatch limits
+const (
+	SqsBatchMessages = 10
+	SqsMaxBytes      = 256 * 1024 // per message and per batch
+	SqsMaxRetries    = 5          // attempts for entries that keep failing
+	SqsMaxIDLength   = 128        // group and deduplication ids
+)
+
+// SqsSink sends rows as JSON messages to an SQS queue, one
+// row per message or several as a JSON array. FIFO queues
+// (names ending in .fifo) get a message group id from the
+// group column and a deduplication id from the primary key.
+type SqsSink struct {
+	*SinkCore
+	Cfg config.Config
+
+	svc      *sqs.SQS
+	queueURL string
+	fifo     bool
+	schema   *mysqlutils.Schema
+	rejects  *Rejects
+
+	// message being filled
+	rows     [][]byte
+	rowBytes int
+	group    string
+	firstKey string
+	lastKey  string
+	messages int // messages closed so far
+
+	// batch being filled
+	entries    []*sqs.SendMessageBatchRequestEntry
+	entryBytes int
+
+	sentCount  int64
+	retryCount int64
+}
+
+func NewSqsSink(name string, cfg config.Config) *SqsSink {
+	q := cfg.GetSqs()
+	c := cfg.GetAws()
+	if q.GetEndpoint() != "" {
+		c = c.Copy().WithEndpoint(q.GetEndpoint())
+	}
+	svc := sqs.New(session.New(c))
+
+	queue := q.GetQueue(name)
+	queueURL := queue
+	if !strings.HasPrefix(queue, "http://") && !strings.HasPrefix(queue, "https://") {
+		resp, err := svc.GetQueueUrl(&sqs.GetQueueUrlInput{QueueName: aws.String(queue)})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"queue": queue,
+				"error": err,
+			}).Fatal("Could not find the SQS queue")
+		}
+		queueURL = aws.StringValue(resp.QueueUrl)
+	}
+	log.WithField("url", queueURL).Info("skrape to queue")
+
+	sink := &SqsSink{
+		Cfg:      cfg,
+		svc:      svc,
+		queueURL: queueURL,
+		fifo:     strings.HasSuffix(queue, ".fifo"),
+		SinkCore: NewSinkCore(name, q.GetRowsPerMessage()),
+	}
+	sink.schema = TableSchema(cfg, name)
+	sink.rejects = TableRejects(cfg, name)
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for sending
+// the exported table to the queue.
+func (s *SqsSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		wg.Done()
+		log.Debug("Channel closed, table should be fully queued")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		if err := s.add(msg); err != nil {
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"error":     err,
+				"msg":       msg,
+			}).Error("Could not queue row")
+			s.Fail(err)
+		}
+	}
+}
+
+// Send the last message and batch
+func (s *SqsSink) ReadFinished() {
+	if s.Err() == nil {
+		err := s.closeMessage()
+		if err == nil {
+			err = s.sendBatch()
+		}
+		s.Fail(err)
+	}
+	if err := s.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": s.Name,
+			"queue":     s.queueURL,
+			"error":     err,
+		}).Error("SQS delivery failed")
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": s.Name,
+		"queue":     s.queueURL,
+		"messages":  s.sentCount,
+		"retried":   s.retryCount,
+	}).Info("Sent to SQS")
+}
+
+func (s *SqsSink) Close() {
+	s.SinkCore.Close()
+	s.rows = nil
+	s.entries = nil
+	s.schema = nil
+}
+
+// Add a row to the message being filled. A message is closed
+// when it is full, would grow too large or, on a FIFO queue,
+// when the row belongs to another message group.
+func (s *SqsSink) add(msg string) error {
+	record, err := newRecord(s.schema, msg)
+	if err != nil {
+		return s.rejects.Reject(StageParse, "sqs", "", []byte(msg), err)
+	}
+	jsn, err := record.Json()
+	if err != nil {
+		return err
+	}
+	if len(jsn)+2+s.attributeBytes() > SqsMaxBytes {
+		cause := fmt.Errorf("row of %d bytes is over the SQS message limit of %d", len(jsn), SqsMaxBytes)
+		return s.rejects.Reject(StageOversize, "sqs", recordKey(s.schema, record), jsn, cause)
+	}
+
+	group := s.groupID(record)
+	if len(s.rows) > 0 && (len(s.rows) >= s.BufferSize ||
+		(s.fifo && group != s.group) ||
+		s.rowBytes+len(jsn)+2+s.attributeBytes() > SqsMaxBytes) {
+		if err := s.closeMessage(); err != nil {
+			return err
+		}
+	}
+
+	key := recordKey(s.schema, record)
+	if len(s.rows) == 0 {
+		s.group = group
+		s.firstKey = key
+	}
+	s.lastKey = key
+	s.rows = append(s.rows, jsn)
+	s.rowBytes += len(jsn) + 1
+	return nil
+}
+
+// Turn the collected rows into a batch entry, sending
+// the batch first when the entry doesn't fit
+func (s *SqsSink) closeMessage() error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	var body string
+	if s.BufferSize == 1 {
+		body = string(s.rows[0])
+	} else {
+		parts := make([]string, len(s.rows))
+		for i, r := range s.rows {
+			parts[i] = string(r)
+		}
+		body = "[" + strings.Join(parts, ",") + "]"
+	}
+
+	size := len(body) + s.attributeBytes()
+	if len(s.entries) >= SqsBatchMessages || s.entryBytes+size > SqsMaxBytes {
+		if err := s.sendBatch(); err != nil {
+			return err
+		}
+	}
+
+	entry := &sqs.SendMessageBatchRequestEntry{
+		Id:          aws.String(strconv.Itoa(len(s.entries))),
+		MessageBody: aws.String(body),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"table": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(s.Name),
+			},
+		},
+	}
+	if s.fifo {
+		dedup := s.Name + ":" + s.firstKey
+		if s.firstKey == "" {
+			// rows without a key are told apart by their place in the run
+			dedup = fmt.Sprintf("%s:%s:%d", s.Cfg.GetRunID(), s.Name, s.messages)
+		} else if len(s.rows) > 1 {
+			dedup += "-" + s.lastKey
+		}
+		entry.MessageGroupId = aws.String(sqsID(s.group))
+		entry.MessageDeduplicationId = aws.String(sqsID(dedup))
+	}
+	s.entries = append(s.entries, entry)
+	s.entryBytes += size
+	s.messages++
+
+	s.rows = s.rows[:0]
+	s.rowBytes = 0
+	return nil
+}
+
+// Send the batch, retrying the entries SQS failed on its
+// side. Entries rejected as malformed, or still failing once
+// out of retries, are rejected.
+func (s *SqsSink) sendBatch() error {
+	pending := s.entries
+	wait := 100 * time.Millisecond
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > SqsMaxRetries {
+			cause := fmt.Errorf("%d messages still failing after %d retries", len(pending), SqsMaxRetries)
+			for _, e := range pending {
+				if err := s.reject(e, cause); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if attempt > 0 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+
+		resp, err := s.svc.SendMessageBatch(&sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(s.queueURL),
+			Entries:  pending,
+		})
+		if err != nil {
+			return err
+		}
+
+		byID := map[string]*sqs.SendMessageBatchRequestEntry{}
+		for _, e := range pending {
+			byID[aws.StringValue(e.Id)] = e
+		}
+		var retry []*sqs.SendMessageBatchRequestEntry
+		for _, f := range resp.Failed {
+			if aws.BoolValue(f.SenderFault) {
+				cause := fmt.Errorf("message rejected: %s %s", aws.StringValue(f.Code), aws.StringValue(f.Message))
+				if err := s.reject(byID[aws.StringValue(f.Id)], cause); err != nil {
+					return err
+				}
+				continue
+			}
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"Error":     aws.StringValue(f.Code),
+			}).Debug("failed message")
+			retry = append(retry, byID[aws.StringValue(f.Id)])
+		}
+		if len(retry) > 0 {
+			s.retryCount += int64(len(retry))
+			log.WithFields(log.Fields{
+				"TableName": s.Name,
+				"count":     len(retry),
+			}).Warn("failed messages")
+		}
+		s.sentCount += int64(len(resp.Successful))
+		pending = retry
+	}
+
+	s.entries = s.entries[:0]
+	s.entryBytes = 0
+	return nil
+}
+
+func (s *SqsSink) reject(e *sqs.SendMessageBatchRequestEntry, cause error) error {
+	return s.rejects.Reject(StageDeliver, "sqs", aws.StringValue(e.MessageGroupId), []byte(aws.StringValue(e.MessageBody)), cause)
+}
+
+// Bytes the table attribute adds to every message,
+// SQS counts its name, type and value against the limits
+func (s *SqsSink) attributeBytes() int {
+	return len("table") + len("String") + len(s.Name)
+}
+
+// Message group of a row, the whole table when no column is set
+func (s *SqsSink) groupID(record structs.Record) string {
+	column := s.Cfg.GetSqs().GetGroupColumn()
+	if column == "" {
+		return s.Name
+	}
+	return fmt.Sprintf("%v", record[column])
+}
+
+// Group and deduplication ids allow up to 128 printable
+// characters without spaces. Anything else is hashed.
+func sqsID(id string) string {
+	valid := len(id) > 0 && len(id) <= SqsMaxIDLength
+	for i := 0; valid && i < len(id); i++ {
+		valid = id[i] > ' ' && id[i] <= '~'
+	}
+	if valid {
+		return id
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
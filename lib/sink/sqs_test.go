@@ -0,0 +1,110 @@
+package sink
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/MasteryConnect/skrape/lib/setup"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestSqsID(t *testing.T) {
+	max := strings.Repeat("a", SqsMaxIDLength)
+	tests := []struct {
+		name   string
+		id     string
+		hashed bool
+	}{
+		{"plain", "users:1-2", false},
+		{"punctuation", `t:{"a"}~!`, false},
+		{"longest", max, false},
+		{"too long", max + "a", true},
+		{"empty", "", true},
+		{"space", "new york", true},
+		{"control character", "a\tb", true},
+		{"non-ascii", "café", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sqsID(tt.id)
+			if !tt.hashed {
+				if got != tt.id {
+					t.Errorf("sqsID(%q) = %q, want it unchanged", tt.id, got)
+				}
+				return
+			}
+			if len(got) != 64 || strings.Trim(got, "0123456789abcdef") != "" {
+				t.Errorf("sqsID(%q) = %q, want a SHA-256 hex digest", tt.id, got)
+			}
+			if again := sqsID(tt.id); again != got {
+				t.Errorf("sqsID(%q) changed from %q to %q", tt.id, got, again)
+			}
+		})
+	}
+}
+
+func TestSqsFifoIDs(t *testing.T) {
+	keyed := &mysqlutils.Schema{Fields: []mysqlutils.Field{
+		{Name: "id", Type: "int(11)", Key: "PRI"},
+		{Name: "tenant", Type: "int(11)"},
+		{Name: "name", Type: "varchar(10)"},
+	}}
+	keyless := &mysqlutils.Schema{Fields: []mysqlutils.Field{
+		{Name: "tenant", Type: "int(11)"},
+		{Name: "name", Type: "varchar(10)"},
+	}}
+	type ids struct{ group, dedup string }
+	tests := []struct {
+		name        string
+		schema      *mysqlutils.Schema
+		groupColumn string
+		rowsPerMsg  int
+		rows        []string
+		want        []ids
+	}{
+		{"one row per message", keyed, "", 1, []string{"1,7,a", "2,7,b"},
+			[]ids{{"users", "users:1"}, {"users", "users:2"}}},
+		{"rows joined", keyed, "", 2, []string{"1,7,a", "2,7,b", "3,8,c"},
+			[]ids{{"users", "users:1-2"}, {"users", "users:3"}}},
+		{"group change closes the message", keyed, "tenant", 3, []string{"1,7,a", "2,7,b", "3,8,c", "4,8,d"},
+			[]ids{{"7", "users:1-2"}, {"8", "users:3-4"}}},
+		{"rows without a key", keyless, "tenant", 2, []string{"7,a", "7,b", "7,c"},
+			[]ids{{"7", "run-1:users:0"}, {"7", "run-1:users:1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig(&setup.Connection{}, "run-1", nil, nil, nil, nil,
+				config.NewSqs("", "users.fifo", tt.groupColumn, tt.rowsPerMsg),
+				nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+			s := &SqsSink{
+				SinkCore: NewSinkCore("users", tt.rowsPerMsg),
+				Cfg:      cfg,
+				fifo:     true,
+				schema:   tt.schema,
+				rejects:  &Rejects{table: "users", max: -1},
+			}
+			for _, row := range tt.rows {
+				if err := s.add(row); err != nil {
+					t.Fatalf("add(%q): %v", row, err)
+				}
+			}
+			if err := s.closeMessage(); err != nil {
+				t.Fatal(err)
+			}
+			var got []ids
+			for _, e := range s.entries {
+				got = append(got, ids{aws.StringValue(e.MessageGroupId), aws.StringValue(e.MessageDeduplicationId)})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ids %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("message %d: ids %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
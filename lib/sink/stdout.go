@@ -0,0 +1,67 @@
+package sink
+
+import (
+	"bufio"
+	"os"
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+)
+
+// StdoutSink writes rows to standard output as csv
+// or JSON lines so skrape can feed a Unix pipeline.
+type StdoutSink struct {
+	*SinkCore
+
+	Buffer  *bufio.Writer
+	format  string
+	schema  *mysqlutils.Schema
+	rejects *Rejects
+}
+
+func NewStdoutSink(name string, bufferSize int, cfg config.Config) *StdoutSink {
+	sink := &StdoutSink{
+		Buffer:   bufio.NewWriterSize(os.Stdout, bufferSize),
+		format:   cfg.GetStdout().GetFormat(),
+		SinkCore: NewSinkCore(name, bufferSize),
+	}
+	if sink.format == config.FormatJsonl {
+		sink.schema = TableSchema(cfg, name)
+	}
+	sink.rejects = TableRejects(cfg, name)
+	return sink
+}
+
+// Main writing function for each table.
+// this function is responsible for writing
+// the exported table to stdout.
+func (s *StdoutSink) Write(wg *sync.WaitGroup) {
+	defer func() {
+		s.Buffer.Flush()
+		wg.Done()
+		log.Debug("Channel closed, table should be fully written")
+	}()
+
+	for msg := range s.DataChan {
+		if s.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		row, err := encodeRow(s.format, s.schema, msg)
+		if err != nil {
+			s.Fail(s.rejects.Reject(StageParse, "stdout", "", []byte(msg), err))
+			continue
+		}
+		s.Buffer.Write(row)
+		if err := s.Buffer.WriteByte('\n'); err != nil {
+			log.WithField("error", err).Fatal("Could not write to stdout")
+		}
+	}
+}
+
+func (s *StdoutSink) Close() {
+	s.SinkCore.Close()
+	s.Buffer = nil
+	s.schema = nil
+}
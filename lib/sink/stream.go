@@ -0,0 +1,203 @@
+package sink
+
+import (
+	"sync"
+
+	"github.com/MasteryConnect/skrape/lib/config"
+	"github.com/MasteryConnect/skrape/lib/mysqlutils"
+	"github.com/apex/log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+const (
+	KinesisMinRetention = 24  // hours every stream keeps records
+	KinesisMaxAutoShard = 500 // the default shard limit of an account
+	KinesisTagsPerCall  = 10
+)
+
+// A stream is prepared once per run, even when
+// several tables write to it at the same time
+type streamPrep struct {
+	once sync.Once
+	err  error
+}
+
+var (
+	streamPrepsMu sync.Mutex
+	streamPreps   = map[string]*streamPrep{}
+)
+
+// Make sure the stream exists and is ACTIVE before anything
+// is written: create and configure it when it is missing,
+// reshard it when asked to.
+func (ks *KinesisSink) prepareStream(cfg config.Config) error {
+	streamPrepsMu.Lock()
+	prep, ok := streamPreps[ks.stream]
+	if !ok {
+		prep = &streamPrep{}
+		streamPreps[ks.stream] = prep
+	}
+	streamPrepsMu.Unlock()
+
+	prep.once.Do(func() {
+		prep.err = ks.setupStream(cfg)
+	})
+	return prep.err
+}
+
+func (ks *KinesisSink) setupStream(cfg config.Config) error {
+	k := cfg.GetKinesis()
+	target := ks.shardTarget(cfg)
+
+	resp, err := ks.svc.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(ks.stream),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == kinesis.ErrCodeResourceNotFoundException {
+		if err := ks.createStream(ks.stream, target); err != nil {
+			return err
+		}
+		if err := ks.waitActive(); err != nil {
+			return err
+		}
+		return ks.configureStream(cfg)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ks.waitActive(); err != nil {
+		return err
+	}
+	open := int(aws.Int64Value(resp.StreamDescriptionSummary.OpenShardCount))
+	if k.IsReshard() && open != target {
+		return ks.reshard(open, target)
+	}
+	return nil
+}
+
+// Shard count from the flag, raised to follow the table size
+// when a size per shard is set. Only the count derived from
+// the size is capped. A stream shared by several tables is
+// sized by the first table to prepare it.
+func (ks *KinesisSink) shardTarget(cfg config.Config) int {
+	k := cfg.GetKinesis()
+	target := k.GetShardCount()
+	if target < 1 {
+		target = 1
+	}
+	if k.GetMBPerShard() <= 0 {
+		return target
+	}
+	size, err := mysqlutils.TableSize(cfg.GetConn(), ks.Name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"TableName": ks.Name,
+			"error":     err,
+		}).Warn("Could not read the table size, using the shard count")
+		return target
+	}
+	perShard := int64(k.GetMBPerShard()) * 1024 * 1024
+	n := int((size + perShard - 1) / perShard)
+	if n > KinesisMaxAutoShard {
+		n = KinesisMaxAutoShard
+	}
+	if n > target {
+		target = n
+	}
+	return target
+}
+
+// Move the open shard count to the target. One update can
+// at most double or halve it, larger moves take several.
+func (ks *KinesisSink) reshard(open, target int) error {
+	for open != target {
+		step := target
+		if step > open*2 {
+			step = open * 2
+		} else if step < (open+1)/2 {
+			step = (open + 1) / 2
+		}
+		log.WithFields(log.Fields{
+			"name": ks.stream,
+			"from": open,
+			"to":   step,
+		}).Info("reshard stream")
+		_, err := ks.svc.UpdateShardCount(&kinesis.UpdateShardCountInput{
+			StreamName:       aws.String(ks.stream),
+			TargetShardCount: aws.Int64(int64(step)),
+			ScalingType:      aws.String(kinesis.ScalingTypeUniformScaling),
+		})
+		if err != nil {
+			return err
+		}
+		if err := ks.waitActive(); err != nil {
+			return err
+		}
+		open = step
+	}
+	return nil
+}
+
+// Set retention, encryption and tags on a stream skrape created
+func (ks *KinesisSink) configureStream(cfg config.Config) error {
+	k := cfg.GetKinesis()
+	if hours := k.GetRetention(); hours > KinesisMinRetention {
+		_, err := ks.svc.IncreaseStreamRetentionPeriod(&kinesis.IncreaseStreamRetentionPeriodInput{
+			StreamName:           aws.String(ks.stream),
+			RetentionPeriodHours: aws.Int64(int64(hours)),
+		})
+		if err != nil {
+			return err
+		}
+		if err := ks.waitActive(); err != nil {
+			return err
+		}
+	}
+
+	if key := k.GetKmsKey(); key != "" {
+		_, err := ks.svc.StartStreamEncryption(&kinesis.StartStreamEncryptionInput{
+			StreamName:     aws.String(ks.stream),
+			EncryptionType: aws.String(kinesis.EncryptionTypeKms),
+			KeyId:          aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		if err := ks.waitActive(); err != nil {
+			return err
+		}
+	}
+
+	tags := map[string]*string{}
+	for key, value := range k.GetTags() {
+		tags[key] = aws.String(value)
+		if len(tags) == KinesisTagsPerCall {
+			if err := ks.addTags(tags); err != nil {
+				return err
+			}
+			tags = map[string]*string{}
+		}
+	}
+	if len(tags) > 0 {
+		return ks.addTags(tags)
+	}
+	return nil
+}
+
+func (ks *KinesisSink) addTags(tags map[string]*string) error {
+	_, err := ks.svc.AddTagsToStream(&kinesis.AddTagsToStreamInput{
+		StreamName: aws.String(ks.stream),
+		Tags:       tags,
+	})
+	return err
+}
+
+// Block until the stream is ACTIVE again
+func (ks *KinesisSink) waitActive() error {
+	return ks.svc.WaitUntilStreamExists(&kinesis.DescribeStreamInput{
+		StreamName: aws.String(ks.stream),
+	})
+}
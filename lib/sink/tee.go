@@ -0,0 +1,128 @@
+package sink
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apex/log"
+)
+
+// TeeSink feeds every row of one extraction to several sinks.
+// Each sink succeeds or fails on its own, unless failFast is
+// set, in which case the first failure aborts the table for all.
+type TeeSink struct {
+	*SinkCore
+
+	sinks    []Sink
+	types    []string // sink type of each sink, for reporting
+	failFast bool
+	failed   []string // sink types that failed the table
+}
+
+func NewTeeSink(name string, types []string, sinks []Sink, failFast bool) *TeeSink {
+	return &TeeSink{
+		sinks:    sinks,
+		types:    types,
+		failFast: failFast,
+		SinkCore: NewSinkCore(name, 0),
+	}
+}
+
+// Main writing function for each table.
+// this function is responsible for passing every
+// row of the exported table on to each sink.
+func (t *TeeSink) Write(wg *sync.WaitGroup) {
+	var sinkWait sync.WaitGroup
+	defer func() {
+		for _, s := range t.sinks {
+			s.EndOfData()
+		}
+		sinkWait.Wait()
+		wg.Done()
+		log.Debug("Channel closed, table should be fully passed on")
+	}()
+
+	for _, s := range t.sinks {
+		sinkWait.Add(1)
+		go s.Write(&sinkWait)
+	}
+
+	failed := make([]bool, len(t.sinks))
+	for msg := range t.DataChan {
+		if t.Err() != nil {
+			continue // keep draining so the reader doesn't block
+		}
+		for i, s := range t.sinks {
+			if failed[i] {
+				continue
+			}
+			if err := s.Err(); err != nil {
+				failed[i] = true
+				log.WithFields(log.Fields{
+					"TableName": t.Name,
+					"sink":      t.types[i],
+					"error":     err,
+				}).Error("Sink failed, no more rows are sent to it")
+				if t.failFast {
+					t.abort(fmt.Errorf("%s sink failed: %s", t.types[i], err))
+					break
+				}
+				continue
+			}
+			s.Data(msg)
+		}
+	}
+}
+
+// Let every sink finish the table and report how each one did
+func (t *TeeSink) ReadFinished() {
+	if t.failFast && t.Err() == nil {
+		for i, s := range t.sinks {
+			if err := s.Err(); err != nil {
+				t.abort(fmt.Errorf("%s sink failed: %s", t.types[i], err))
+				break
+			}
+		}
+	}
+
+	t.failed = nil
+	for i, s := range t.sinks {
+		s.ReadFinished()
+		fields := log.Fields{
+			"TableName": t.Name,
+			"sink":      t.types[i],
+		}
+		if err := s.Err(); err != nil {
+			t.failed = append(t.failed, t.types[i])
+			log.WithFields(fields).WithField("error", err).Error("Sink failed")
+		} else {
+			log.WithFields(fields).Info("Sink succeeded")
+		}
+	}
+	// without failFast the table only fails when no sink got it
+	if len(t.failed) > 0 && (t.failFast || len(t.failed) == len(t.sinks)) {
+		t.Fail(fmt.Errorf("failed sinks: %s", strings.Join(t.failed, ", ")))
+	}
+}
+
+// Sink types that failed the table, even when the others got it
+func (t *TeeSink) FailedSinks() []string {
+	return t.failed
+}
+
+func (t *TeeSink) Close() {
+	for _, s := range t.sinks {
+		s.Close()
+	}
+	t.SinkCore.Close()
+	t.sinks = nil
+}
+
+// Fail the table and every sink so none of them finish it
+func (t *TeeSink) abort(err error) {
+	t.Fail(err)
+	for _, s := range t.sinks {
+		s.Fail(err)
+	}
+}
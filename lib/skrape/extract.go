@@ -3,8 +3,11 @@ package skrape
 import (
 	"bufio"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
@@ -13,10 +16,14 @@ import (
 	"github.com/MasteryConnect/skrape/lib/config"
 	utils "github.com/MasteryConnect/skrape/lib/mysqlutils"
 	sinks "github.com/MasteryConnect/skrape/lib/sink"
+	"github.com/MasteryConnect/skrape/lib/skrape/skrapes3"
 	"github.com/MasteryConnect/skrape/lib/utility"
 	"github.com/apex/log"
 )
 
+// A mysqldump line with nothing after its VALUES
+var errNoValues = errors.New("INSERT statement without values")
+
 const (
 	BufferSize       = 209715200 // 200MB in bytes
 	KinesisBatchSize = 1000      // Records per PutRecord call
@@ -25,10 +32,13 @@ const (
 type Extract struct {
 	SinkType string
 	Cfg      config.Config
+
+	mu     sync.Mutex
+	failed []string // tables a sink failed to export
 }
 
 func NewExtract(sinkType string, c config.Config) *Extract {
-	return &Extract{sinkType, c}
+	return &Extract{SinkType: sinkType, Cfg: c}
 }
 
 func (e *Extract) Perform(semaphore chan bool, name string) { // Perform the export from MySQL RDBMS to CSV files
@@ -36,15 +46,11 @@ func (e *Extract) Perform(semaphore chan bool, name string) { // Perform the exp
 	// Source
 	table := NewTable(e.Destination(), name)
 	// Sink
-	var sink sinks.Sink
-	switch e.SinkType {
-	case "csv":
-		sink = sinks.NewCsvSink(e.Destination(), name, BufferSize)
-	case "kinesis":
-		sink = sinks.NewKinesisSink(e.Destination(), name, KinesisBatchSize, e.Cfg)
-	default:
-		sink = sinks.NewS3Sink(e.Destination(), name, BufferSize, e.Cfg)
-	}
+	sink := e.NewSink(e.SinkType, name)
+	rejects := sinks.TableRejects(e.Cfg, name)
+	// spatial values come out of mysqldump as binary, the sinks get WKT
+	schema := sinks.TableSchema(e.Cfg, name)
+	spatial := schema.SpatialColumns()
 	log.Debug("Inside Perform Function")
 
 	defer func() {
@@ -114,11 +120,20 @@ func (e *Extract) Perform(semaphore chan bool, name string) { // Perform the exp
 			}
 
 			if len(txt) > preambleLen {
-				parsed := txt[preambleLen : len(txt)-2]           // Drop off ); at end of line
-				sink.Data(utility.MysqlInsertValuesToCsv(parsed)) // add parsed line to the channel
-			} else { // log out bad value strings and continue
+				parsed := txt[preambleLen : len(txt)-2] // Drop off ); at end of line
+				if len(spatial) == 0 {
+					sink.Data(utility.MysqlInsertValuesToCsv(parsed)) // add parsed line to the channel
+					continue
+				}
+				row, err := spatialToWKT(schema, spatial, parsed)
+				if err != nil {
+					sink.Fail(rejects.Reject(sinks.StageExtract, "", "", []byte(txt), err))
+					continue
+				}
+				sink.Data(row)
+			} else { // reject bad value strings and continue
 				log.Debug("BAD JOO JOO found in extraction")
-				log.Warn(fmt.Sprintf("%s\n", txt))
+				sink.Fail(rejects.Reject(sinks.StageExtract, "", "", []byte(txt), errNoValues))
 				continue
 			}
 		}
@@ -159,7 +174,147 @@ func (e *Extract) Perform(semaphore chan bool, name string) { // Perform the exp
 		}).Error(err.Error())
 	}
 	sink.ReadFinished()
+	if err := sink.Err(); err != nil {
+		log.WithFields(log.Fields{
+			"TableName": name,
+			"error":     err,
+		}).Error("Table export failed")
+		e.fail(name)
+	} else if tee, ok := sink.(*sinks.TeeSink); ok && len(tee.FailedSinks()) > 0 {
+		e.fail(name) // some sinks got the table, the run still failed
+	}
 	sink.Close()
+	sinks.ReleaseTableSchema(name)
+	e.closeRejects(rejects)
+}
+
+// Csv row of the INSERT values with the spatial columns as WKT
+func spatialToWKT(schema *utils.Schema, spatial []int, values string) (string, error) {
+	fields := utility.MysqlInsertValuesToFields(values)
+	for _, i := range spatial {
+		if i >= len(fields) {
+			break // the sinks reject rows with missing values
+		}
+		wkt, err := utils.SpatialFieldToWKT(fields[i])
+		if err != nil {
+			return "", fmt.Errorf("column %s: %v", schema.Fields[i].Name, err)
+		}
+		fields[i] = wkt
+	}
+	return strings.Join(fields, ","), nil
+}
+
+// Record a table that did not make it to every sink
+func (e *Extract) fail(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failed = append(e.failed, name)
+}
+
+// Tables that failed during the run
+func (e *Extract) Failed() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.failed...)
+}
+
+// Close the dead letter file of a finished table and
+// upload it when asked to. Tables without rejects have none.
+func (e *Extract) closeRejects(rejects *sinks.Rejects) {
+	rejects.Close()
+	if rejects.Count() == 0 {
+		return
+	}
+	log.WithFields(log.Fields{
+		"TableName": rejects.Table(),
+		"count":     rejects.Count(),
+		"file":      rejects.Path(),
+	}).Warn("Rows rejected")
+	if !rejects.IsRecorded() || !e.Cfg.GetDeadLetter().IsUpload() {
+		return
+	}
+	file := filepath.Base(rejects.Path())
+	s3cfg := e.Cfg.GetS3()
+	f, err := os.Open(rejects.Path())
+	if err == nil {
+		defer f.Close()
+		_, err = skrapes3.StreamUpload(f, s3cfg.GetBucket(), e.Cfg.GetS3Key("dead-letter", rejects.Table(), file), s3cfg.GetPartSize(), s3cfg.GetConcurrency(), s3cfg.GetMaxRetries())
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"file":  rejects.Path(),
+			"error": err,
+		}).Error("Could not upload the dead letter file")
+	}
+}
+
+// Create the sink of the given type for a table
+func (e *Extract) NewSink(sinkType, name string) sinks.Sink {
+	switch sinkType {
+	case "csv":
+		return sinks.NewCsvSink(e.Destination(), name, BufferSize)
+	case "kinesis":
+		return sinks.NewKinesisSink(e.Destination(), name, KinesisBatchSize, e.Cfg)
+	case "firehose":
+		return sinks.NewFirehoseSink(name, e.Cfg)
+	case "kafka":
+		return sinks.NewKafkaSink(name, e.Cfg)
+	case "sqs":
+		return sinks.NewSqsSink(name, e.Cfg)
+	case "dynamodb":
+		return sinks.NewDynamodbSink(name, e.Cfg)
+	case "elasticsearch":
+		return sinks.NewElasticsearchSink(name, e.Cfg)
+	case "postgres":
+		return sinks.NewPostgresSink(name, e.Cfg)
+	case "mysql":
+		return sinks.NewMysqlSink(name, e.Cfg)
+	case "sqlite":
+		return sinks.NewSqliteSink(name, e.Cfg)
+	case "stdout":
+		return sinks.NewStdoutSink(name, sinks.StreamBufferSize, e.Cfg)
+	case "exec":
+		return sinks.NewExecSink(name, e.Cfg)
+	case "http":
+		return sinks.NewHttpSink(name, e.Cfg)
+	case "tee":
+		tee := e.Cfg.GetTee()
+		var children []sinks.Sink
+		for _, t := range tee.GetSinks() {
+			children = append(children, e.NewSink(t, name))
+		}
+		return sinks.NewTeeSink(name, tee.GetSinks(), children, tee.IsFailFast())
+	}
+	if e.Cfg.GetS3().IsStream() {
+		return sinks.NewS3StreamSink(name, e.Cfg)
+	}
+	return sinks.NewS3Sink(e.Destination(), name, BufferSize, e.Cfg)
+}
+
+// Sink types the rows are written to, more than one under tee
+func (e *Extract) SinkTypes() []string {
+	if e.SinkType == "tee" {
+		return e.Cfg.GetTee().GetSinks()
+	}
+	return []string{e.SinkType}
+}
+
+// Upload the manifest listing the data files of every
+// table exported during the run. Tables that failed
+// are left out so a loader only sees complete files.
+func (e *Extract) UploadRunManifest() {
+	s3cfg := e.Cfg.GetS3()
+	if !utility.StringInSlice("s3", e.SinkTypes()) || !s3cfg.IsManifest() {
+		return
+	}
+	file := fmt.Sprintf("run-%s.manifest", e.Cfg.GetRunID())
+	skrapes3.RunManifest.Upload(s3cfg.GetBucket(), e.Cfg.GetS3Key("manifests", "", file))
+	log.WithField("entries", len(skrapes3.RunManifest.Entries)).Info("Run manifest uploaded")
+}
+
+// Release what the sinks share across tables
+func (e *Extract) Close() {
+	sinks.CloseDeadLetters()
 }
 
 // Abstraction functions for disconnecting
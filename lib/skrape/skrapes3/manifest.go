@@ -0,0 +1,52 @@
+package skrapes3
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Redshift COPY manifest listing the exact objects to load
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+	mu      sync.Mutex
+}
+
+type ManifestEntry struct {
+	URL       string       `json:"url"`
+	Mandatory bool         `json:"mandatory"`
+	Meta      ManifestMeta `json:"meta"`
+}
+
+type ManifestMeta struct {
+	ContentLength int64 `json:"content_length"`
+}
+
+// Collects the data files of every table uploaded during the run
+var RunManifest *Manifest = NewManifest()
+
+func NewManifest() *Manifest {
+	return &Manifest{Entries: []ManifestEntry{}}
+}
+
+// Add an uploaded object to the manifest. Safe to call
+// from the goroutines of several tables at once.
+func (m *Manifest) Add(bucket, key string, contentLength int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries = append(m.Entries, ManifestEntry{
+		URL:       fmt.Sprintf("s3://%s/%s", bucket, key),
+		Mandatory: true,
+		Meta:      ManifestMeta{ContentLength: contentLength},
+	})
+}
+
+// Upload the manifest, exits the app with Fatal on failure
+func (m *Manifest) Upload(bucket, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(m)
+	S3Upload(bytes.NewReader(buf.Bytes()), bucket, key)
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"time"
 
 	"github.com/apex/log"
 	"github.com/aws/aws-sdk-go/aws"
@@ -25,15 +24,34 @@ func NewFile(name, path string) File {
 	return File{name, path}
 }
 
-// Stream a gzipped export file to S3
-func Gzipload(name, path string) {
+// Counts the bytes written through it, used to find
+// the content length of uploads with a streamed body
+type CountingWriter struct {
+	Writer io.Writer
+	Count  int64
+}
+
+func NewCountingWriter(w io.Writer) *CountingWriter {
+	return &CountingWriter{Writer: w}
+}
+
+func (c *CountingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.Count += int64(n)
+	return n, err
+}
+
+// Stream a gzipped export file to S3.
+// Returns the size of the uploaded object.
+func Gzipload(name, path, bucket, key string) int64 {
 	file, err := os.Open(fmt.Sprintf("%s/%s", path, name+".csv"))
 	if err != nil {
 		log.WithField("error", err).Fatal("There was an error opening the extracted file for gzipping")
 	}
 	reader, writer := io.Pipe()
+	counter := NewCountingWriter(writer)
 	go func() {
-		gw := gzip.NewWriter(writer)
+		gw := gzip.NewWriter(counter)
 		_, err := io.Copy(gw, file)
 		if err != nil {
 			log.WithField("error", err).Fatal("There was an error gzipping a file")
@@ -45,8 +63,8 @@ func Gzipload(name, path string) {
 	uploader := s3manager.NewUploader(AWSSession)
 	result, err := uploader.Upload(&s3manager.UploadInput{
 		Body:   reader,
-		Bucket: aws.String(os.Getenv("S3_BUCKET")),
-		Key:    aws.String(fmt.Sprintf("%s/%s/data/%s", os.Getenv("S3_KEY"), S3DateKey(), name+".csv.gz")),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
 	})
 	if err != nil {
 		log.WithField("error", err).Fatal("Failed to upload file.")
@@ -56,11 +74,31 @@ func Gzipload(name, path string) {
 		log.WithField("error", err).Warn("A csv file was not removed!")
 	}
 	log.WithField("location", result.Location).Info("Successfully uploaded to")
+	return counter.Count
+}
+
+// Stream a body of unknown length to S3 as a multipart upload.
+// s3manager buffers one part per upload goroutine, so memory use
+// is bounded by partSize * concurrency no matter how large the body
+// is. Every part request is retried up to maxRetries times before
+// the whole upload is aborted.
+func StreamUpload(body io.Reader, bucket, key string, partSize int64, concurrency, maxRetries int) (*s3manager.UploadOutput, error) {
+	sess := AWSSession.Copy(&aws.Config{MaxRetries: aws.Int(maxRetries)})
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+		u.LeavePartsOnError = false
+	})
+	return uploader.Upload(&s3manager.UploadInput{
+		Body:   body,
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
 }
 
 // Upload a file to S3, function will exit
 // app with Fatal if there is an issue uploading the file
-func S3Upload(body *os.File, bucket, key string) {
+func S3Upload(body io.ReadSeeker, bucket, key string) {
 	s3Svc := s3.New(AWSSession)
 
 	_, err := s3Svc.PutObject(&s3.PutObjectInput{
@@ -69,12 +107,6 @@ func S3Upload(body *os.File, bucket, key string) {
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		log.WithField("error", err).Fatal(fmt.Sprintf("There was an error uploading %s to S3", body.Name()))
+		log.WithField("error", err).Fatal(fmt.Sprintf("There was an error uploading %s to S3", key))
 	}
 }
-
-// Returns a string consisting of todays date
-// to be used as the key (path) for the S3 export
-func S3DateKey() (key string) {
-	return time.Now().Format("2006/01/02")
-}
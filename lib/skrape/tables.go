@@ -30,16 +30,8 @@ func (t *Table) AddTable(a []string) (args []string) {
 // Handles the control flow of exporting all tables from a database.
 // This funciton institutes a semaphore pattern for controlling
 // how many tables are exporting at once.
-func (e *Extract) TableHandler(priority, exclude []string) { // grab all tables from the database
-	tableNames := e.ReadTables()
-
-	// if priority is flagged, move the tables to the front of the list
-	if len(priority) > 0 {
-		tableNames = utility.MoveToFrontOfSlice(priority, tableNames)
-	}
-	if len(exclude) > 0 {
-		tableNames = utility.SlcDelFrmSlc(exclude, tableNames)
-	}
+func (e *Extract) TableHandler(priority, exclude []string) {
+	tableNames := e.TableNames(priority, exclude)
 
 	semaphore := make(chan bool, e.Concurrency())
 	for _, name := range tableNames {
@@ -58,6 +50,21 @@ func (e *Extract) TableHandler(priority, exclude []string) { // grab all tables
 	log.Debug("Looped all tables, should be exiting")
 }
 
+// Grab all tables from the database, with the priority
+// tables first and the excluded tables left out.
+func (e *Extract) TableNames(priority, exclude []string) []string {
+	tableNames := e.ReadTables()
+
+	// if priority is flagged, move the tables to the front of the list
+	if len(priority) > 0 {
+		tableNames = utility.MoveToFrontOfSlice(priority, tableNames)
+	}
+	if len(exclude) > 0 {
+		tableNames = utility.SlcDelFrmSlc(exclude, tableNames)
+	}
+	return tableNames
+}
+
 // Pull all the table names from the database provided excluding views.
 // Returns a slice of strings containing the table names.
 func (e *Extract) ReadTables() []string {
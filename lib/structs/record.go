@@ -8,7 +8,7 @@ import (
 type Record map[string]interface{}
 
 func (rec *Record) GetID() string {
-	return fmt.Sprintf("%d", (*rec)["id"])
+	return fmt.Sprintf("%v", (*rec)["id"])
 }
 
 func (rec Record) String() string {
@@ -7,7 +7,7 @@ import (
 )
 
 func Cleanup(path string) {
-	fmt.Println("CLEANING UP")
+	fmt.Fprintln(os.Stderr, "CLEANING UP")
 
 	if _, err := os.Stat(path); err == nil {
 		err := os.Remove(path)
@@ -64,11 +64,115 @@ func StrDelFrmSlc(a string, b []string) []string {
 	return b
 }
 
-// Convert Mysql INSERT values list into the correct CSV format
+// Convert Mysql INSERT values list into the correct CSV format.
+// Strings are always quoted, whatever they hold. Their backslash
+// escapes are kept for MysqlUnescape, except \" which csv doubles.
+// Literals like b'0101' are quoted as they are, charset
+// introducers like _binary are dropped.
 func MysqlInsertValuesToCsv(values string) string {
-	csv := strings.Replace(values, "\\\"", "\"\"", -1)
-	csv = strings.Replace(csv, ",'", ",\"", -1)
-	return strings.Replace(csv, "',", "\",", -1)
+	return strings.Join(MysqlInsertValuesToFields(values), ",")
+}
+
+// Split a Mysql INSERT values list into csv fields, encoded as
+// MysqlInsertValuesToCsv does
+func MysqlInsertValuesToFields(values string) []string {
+	var fields []string
+	i := 0
+	for {
+		var out strings.Builder
+		start := i
+		for i < len(values) && values[i] != ',' && values[i] != '\'' {
+			i++
+		}
+		token := strings.TrimSpace(values[start:i])
+		if i < len(values) && values[i] == '\'' {
+			if token != "" && !strings.HasPrefix(token, "_") {
+				end := i + 1 + strings.IndexByte(values[i+1:], '\'')
+				if end <= i {
+					end = len(values) - 1
+				}
+				out.WriteString(`"` + token + values[i:end+1] + `"`)
+				i = end + 1
+			} else {
+				i = writeCsvString(&out, values, i)
+			}
+			for i < len(values) && values[i] != ',' {
+				i++ // only spaces are left before the comma
+			}
+		} else {
+			out.WriteString(token)
+		}
+		fields = append(fields, out.String())
+		if i >= len(values) {
+			return fields
+		}
+		i++
+	}
+}
+
+// Write the quoted string starting at values[i] as a csv field
+// and return the index after its closing quote
+func writeCsvString(out *strings.Builder, values string, i int) int {
+	out.WriteByte('"')
+	for i++; i < len(values); i++ {
+		c := values[i]
+		switch {
+		case c == '\\' && i+1 < len(values):
+			i++
+			if values[i] == '"' {
+				out.WriteString(`""`)
+			} else {
+				out.WriteByte('\\')
+				out.WriteByte(values[i])
+			}
+		case c == '\'' && i+1 < len(values) && values[i+1] == '\'':
+			out.WriteString(`\'`) // '' is an escaped quote too
+			i++
+		case c == '\'':
+			out.WriteByte('"')
+			return i + 1
+		case c == '"':
+			out.WriteString(`""`)
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteByte('"') // unterminated, close it anyway
+	return i
+}
+
+// Replace the backslash escape sequences mysqldump writes
+// inside string values with the characters they stand for
+func MysqlUnescape(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	buf := make([]byte, 0, len(value))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '\\' || i+1 == len(value) {
+			buf = append(buf, c)
+			continue
+		}
+		i++
+		switch value[i] {
+		case '0':
+			buf = append(buf, 0)
+		case 'b':
+			buf = append(buf, '\b')
+		case 'n':
+			buf = append(buf, '\n')
+		case 'r':
+			buf = append(buf, '\r')
+		case 't':
+			buf = append(buf, '\t')
+		case 'Z':
+			buf = append(buf, 26) // ctrl-Z
+		default: // \\ \' \" and anything else stand for themselves
+			buf = append(buf, value[i])
+		}
+	}
+	return string(buf)
 }
 
 // Check the array for strings with commas. If a string with commas is found
@@ -0,0 +1,63 @@
+package utility
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMysqlInsertValuesToFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		values string
+		want   []string
+	}{
+		{"numbers and null", `1,-2.5,NULL`, []string{`1`, `-2.5`, `NULL`}},
+		{"plain string", `1,'abc'`, []string{`1`, `"abc"`}},
+		{"empty string", `''`, []string{`""`}},
+		{"embedded comma", `'a,b',2`, []string{`"a,b"`, `2`}},
+		{"escaped newline", `'a\nb'`, []string{`"a\nb"`}},
+		{"raw newline", "'a\nb'", []string{"\"a\nb\""}},
+		{"escaped backslash before the closing quote", `'a\\',1`, []string{`"a\\"`, `1`}},
+		{"escaped single quote", `'it\'s'`, []string{`"it\'s"`}},
+		{"doubled single quote", `'it''s'`, []string{`"it\'s"`}},
+		{"escaped double quote", `'say \"hi\"'`, []string{`"say ""hi"""`}},
+		{"bare double quote", `'say "hi"'`, []string{`"say ""hi"""`}},
+		{"json document", `'{\"a\": [1, 2]}'`, []string{`"{""a"": [1, 2]}"`}},
+		{"binary introducer", `_binary 'ab\0c',1`, []string{`"ab\0c"`, `1`}},
+		{"bit literal", `b'0101',1`, []string{`"b'0101'"`, `1`}},
+		{"hex literal", `0x0A1F,NULL`, []string{`0x0A1F`, `NULL`}},
+		{"spaces around values", ` 1 , 'a' ,NULL`, []string{`1`, `"a"`, `NULL`}},
+		{"unterminated string", `'abc`, []string{`"abc"`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MysqlInsertValuesToFields(tt.values)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MysqlInsertValuesToFields(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteCsvString(t *testing.T) {
+	tests := []struct {
+		values string
+		want   string
+		next   int
+	}{
+		{`'abc',1`, `"abc"`, 5},
+		{`'a\\'`, `"a\\"`, 5},
+		{`'a\\\''`, `"a\\\'"`, 7},
+		{`'\"'`, `""""`, 4},
+		{`''''`, `"\'"`, 4},
+		{`'a,b'`, `"a,b"`, 5},
+	}
+	for _, tt := range tests {
+		var out strings.Builder
+		next := writeCsvString(&out, tt.values, 0)
+		if out.String() != tt.want || next != tt.next {
+			t.Errorf("writeCsvString(%q) = %q, %d, want %q, %d", tt.values, out.String(), next, tt.want, tt.next)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/MasteryConnect/skrape/lib/config"
@@ -17,6 +19,9 @@ import (
 
 const Concurrency = 10
 
+// Sinks that can be combined under tee
+var SinkTypes = []string{"s3", "csv", "kinesis", "firehose", "kafka", "sqs", "dynamodb", "elasticsearch", "postgres", "mysql", "sqlite", "stdout", "exec", "http"}
+
 // cli flag vars
 var (
 	mysqlDumpPath         string
@@ -34,7 +39,88 @@ var (
 	kinesisStreamName     string
 	kinesisStreamEndpoint string
 	kinesisShardCount     int
+	kinesisAggregate      bool
+	kinesisPartitionKeys  cli.StringSlice
+	kinesisRetries        int
+	kinesisRateLimit      bool
+	kinesisReshard        bool
+	kinesisMBPerShard     int
+	kinesisRetention      int
+	kinesisKmsKey         string
+	kinesisTags           cli.StringSlice
+	kinesisOversize       string
+	kinesisTruncateCols   cli.StringSlice
+	deadLetterDir         string
+	deadLetterUpload      bool
+	maxRejects            int
+	decimals              string
+	jsonColumns           string
+	timezone              string
+	columnTypes           cli.StringSlice
+	firehoseStreamName    string
+	firehoseEndpoint      string
+	kafkaBrokers          cli.StringSlice
+	kafkaTopic            string
+	kafkaAcks             string
+	kafkaCompression      string
+	kafkaBatchSize        int
+	kafkaLinger           int
+	kafkaIdempotent       bool
+	kafkaCreateTopic      bool
+	kafkaPartitions       int
+	kafkaReplication      int
+	sqsQueue              string
+	sqsEndpoint           string
+	sqsGroupColumn        string
+	sqsRowsPerMessage     int
+	dynamodbTable         string
+	dynamodbEndpoint      string
+	dynamodbCreateTable   bool
+	esURL                 string
+	esIndex               string
+	esAlias               string
+	esCreateIndex         bool
+	esBulkSize            int
+	esDeleteOld           bool
 	awsRegion             string
+	runID                 string
+	s3Bucket              string
+	s3Prefix              string
+	s3KeyTemplate         string
+	s3Manifest            bool
+	s3DDL                 cli.StringSlice
+	s3Stream              bool
+	s3PartSize            int
+	s3Concurrency         int
+	s3MaxRetries          = config.DefaultMaxRetries // also used by commands without the s3 flags
+	ddlDialect            string
+	pgURL                 string
+	pgSchema              string
+	pgMode                string
+	targetHost            string
+	targetPort            string
+	targetUser            string
+	targetPwd             string
+	targetDatabase        string
+	targetMethod          string
+	targetBatchSize       int
+	targetRecreate        bool
+	targetTruncate        bool
+	targetDisableKeys     bool
+	sqlitePath            string
+	sqliteBatchSize       int
+	rowFormat             string
+	execCommand           string
+	httpURL               string
+	httpBatchSize         int
+	httpBatchBytes        int
+	httpGzip              bool
+	httpHeaders           cli.StringSlice
+	httpToken             string
+	httpBasicAuth         string
+	httpRetries           int
+	teeSinks              cli.StringSlice
+	teeFailFast           bool
 )
 
 func init() {
@@ -112,6 +198,78 @@ func main() {
 			Usage:       "set concurrency level to the number of tables being exported",
 			Destination: &matchTables,
 		},
+		cli.StringFlag{
+			Name:        "run-id",
+			Usage:       "id shared by every object written in this run (defaults to the start time, e.g. 20160829T040000Z)",
+			Destination: &runID,
+		},
+		cli.StringFlag{
+			Name:        "dead-letter",
+			Usage:       "directory rejected rows are written to, one JSON lines file per table with the row, the stage that rejected it and the error",
+			Destination: &deadLetterDir,
+		},
+		cli.BoolFlag{
+			Name:        "dead-letter-s3",
+			Usage:       "upload each table's dead letter file to --bucket under the dead-letter type",
+			Destination: &deadLetterUpload,
+		},
+		cli.IntFlag{
+			Name:        "max-rejects",
+			Usage:       "fail a table once more than this many of its rows are rejected. -1 for no limit. Rejected rows are kept by --dead-letter, otherwise only logged",
+			Value:       -1,
+			Destination: &maxRejects,
+		},
+		cli.StringFlag{
+			Name:        "decimals",
+			Usage:       "how typed records write DECIMAL columns: decimal (exact string), scaled (integer of the column's smallest unit, e.g. cents) or float",
+			Value:       mysqlutils.KindDecimal,
+			Destination: &decimals,
+		},
+		cli.StringFlag{
+			Name:        "json-columns",
+			Usage:       "how typed records write JSON columns: json (nested in the record) or string",
+			Value:       mysqlutils.KindJson,
+			Destination: &jsonColumns,
+		},
+		cli.StringFlag{
+			Name:        "timezone",
+			Usage:       "time zone DATETIME values are in, e.g. America/Denver. Typed records write them as ISO-8601 with the offset",
+			Value:       "UTC",
+			Destination: &timezone,
+		},
+		cli.StringSliceFlag{
+			Name:  "column-type",
+			Usage: "kind typed records write a column as, as [table.]column=kind. Kinds: " + strings.Join(mysqlutils.Kinds, ", ") + ". This can be a comma seperated list and/or multiple --column-type args",
+			Value: &columnTypes,
+		},
+		cli.StringFlag{
+			Name:        "b, bucket",
+			Usage:       "S3 bucket to upload exports to",
+			Destination: &s3Bucket,
+			EnvVar:      "S3_BUCKET",
+		},
+		cli.StringFlag{
+			Name:        "prefix",
+			Usage:       "S3 key prefix every uploaded object is placed under",
+			Destination: &s3Prefix,
+			EnvVar:      "S3_KEY",
+		},
+		cli.StringFlag{
+			Name:        "key-template",
+			Usage:       "template for S3 keys below the prefix. Placeholders: {DB} {TABLE} {DATE} (YYYY/MM/DD in UTC, as are the other dates) {DT} (Hive style dt=YYYY-MM-DD) {YEAR} {MONTH} {DAY} {RUN_ID} {TYPE} (data, schemas, paths, manifests, oversize or dead-letter) {FILE}. The file name is appended unless {FILE} is used",
+			Value:       config.DefaultKeyTemplate,
+			Destination: &s3KeyTemplate,
+		},
+		cli.BoolFlag{
+			Name:        "manifest",
+			Usage:       "upload a Redshift COPY manifest per table and one for the whole run under the manifests type",
+			Destination: &s3Manifest,
+		},
+		cli.StringSliceFlag{
+			Name:  "ddl",
+			Usage: "upload a CREATE TABLE statement per table for each dialect listed (redshift, postgres, sqlite) under the ddl type",
+			Value: &s3DDL,
+		},
 		cli.BoolFlag{
 			Name:        "p, skip-pass",
 			Usage:       "do not prompt for password, instead use the env var",
@@ -125,6 +283,7 @@ func main() {
 			Name:    "s3",
 			Aliases: []string{"s"},
 			Usage:   "export to csv files that are uploaded to s3",
+			Flags:   s3Flags,
 			Action: func(c *cli.Context) error {
 				return action(c, "s3")
 			},
@@ -141,34 +300,133 @@ func main() {
 			Name:    "kinesis",
 			Aliases: []string{"k"},
 			Usage:   "export to an AWS Kinesis stream",
-			Flags: []cli.Flag{
-				cli.StringFlag{
-					Name:        "s, stream-name",
-					Usage:       "Kinesis stream name. If the stream name includes the string {TABLE_NAME}, it will be replaced by the table name, allowing for 1 stream per table",
-					Destination: &kinesisStreamName,
-				},
-				cli.StringFlag{
-					Name:        "e, stream-endpoint",
-					Usage:       "Kinesis stream URL endpoint",
-					Destination: &kinesisStreamEndpoint,
-				},
-				cli.StringFlag{
-					Name:        "r, region",
-					Usage:       "AWS region",
-					Destination: &awsRegion,
-					EnvVar:      "AWS_REGION",
+			Flags:   kinesisFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "kinesis")
+			},
+		},
+		{
+			Name:  "firehose",
+			Usage: "export to Kinesis Data Firehose delivery streams",
+			Flags: firehoseFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "firehose")
+			},
+		},
+		{
+			Name:  "kafka",
+			Usage: "publish the rows as JSON messages to Kafka topics, keyed by primary key",
+			Flags: kafkaFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "kafka")
+			},
+		},
+		{
+			Name:  "sqs",
+			Usage: "send the rows as JSON messages to SQS queues",
+			Flags: sqsFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "sqs")
+			},
+		},
+		{
+			Name:  "dynamodb",
+			Usage: "write the rows as items into DynamoDB tables",
+			Flags: dynamodbFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "dynamodb")
+			},
+		},
+		{
+			Name:    "elasticsearch",
+			Aliases: []string{"es"},
+			Usage:   "index the rows as documents in Elasticsearch or OpenSearch through the _bulk API",
+			Flags:   elasticsearchFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "elasticsearch")
+			},
+		},
+		{
+			Name:    "postgres",
+			Aliases: []string{"pg"},
+			Usage:   "load the exported tables into PostgreSQL with COPY, creating missing tables from the MySQL schema",
+			Flags:   postgresFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "postgres")
+			},
+		},
+		{
+			Name:  "mysql",
+			Usage: "copy the exported tables into a second MySQL server",
+			Flags: mysqlFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "mysql")
+			},
+		},
+		{
+			Name:  "sqlite",
+			Usage: "export the tables into a single SQLite database file",
+			Flags: sqliteFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "sqlite")
+			},
+		},
+		{
+			Name:  "stdout",
+			Usage: "write the rows of a single --table to stdout for use in a pipeline, logging goes to stderr",
+			Flags: stdoutFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "stdout")
+			},
+		},
+		{
+			Name:  "tee",
+			Usage: "feed every exported table to several sinks at once, taking the flags of each sink listed in --sinks",
+			Flags: append([]cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "sinks",
+					Usage: "sinks to write to (s3, csv, kinesis, firehose, kafka, sqs, dynamodb, elasticsearch, postgres, mysql, sqlite, stdout, exec, http). This can be a comma seperated list and/or multiple --sinks args",
+					Value: &teeSinks,
 				},
-				cli.IntFlag{
-					Name:        "c, shard-count",
-					Usage:       "number of shards for this stream",
-					Value:       1,
-					Destination: &kinesisShardCount,
+				cli.BoolFlag{
+					Name:        "fail-fast",
+					Usage:       "abort a table in every sink as soon as one sink fails instead of letting the others finish it",
+					Destination: &teeFailFast,
 				},
+			}, mergeFlags(s3Flags, kinesisFlags, firehoseFlags, kafkaFlags, sqsFlags, dynamodbFlags, elasticsearchFlags, postgresFlags, mysqlFlags, sqliteFlags, stdoutFlags, execFlags, httpFlags)...),
+			Action: func(c *cli.Context) error {
+				return action(c, "tee")
 			},
+		},
+		{
+			Name:  "exec",
+			Usage: "pipe the rows of every table into the stdin of an external command",
+			Flags: execFlags,
 			Action: func(c *cli.Context) error {
-				return action(c, "kinesis")
+				return action(c, "exec")
+			},
+		},
+		{
+			Name:  "http",
+			Usage: "post the rows in batches of JSON records to a webhook",
+			Flags: httpFlags,
+			Action: func(c *cli.Context) error {
+				return action(c, "http")
 			},
 		},
+		{
+			Name:  "ddl",
+			Usage: "print CREATE TABLE statements for the exported tables without exporting any data",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "dialect",
+					Usage:       "dialect of the statements (redshift, postgres, sqlite)",
+					Value:       mysqlutils.Redshift,
+					Destination: &ddlDialect,
+				},
+			},
+			Action: ddlAction,
+		},
 	}
 	// Default action if no command specified
 	app.Action = func(c *cli.Context) error {
@@ -178,6 +436,15 @@ func main() {
 }
 
 func action(c *cli.Context, sinkType string) error {
+	if err := validate(sinkType); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if deadLetterUpload && (deadLetterDir == "" || s3Bucket == "") {
+		return cli.NewExitError("--dead-letter-s3 needs --dead-letter and --bucket", 1)
+	}
+	if err := config.NewTypes(decimals, jsonColumns, timezone, utility.ExtractAndAppendCommaDelimitedStrings(columnTypes)).Check(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 	start := time.Now()
 	defer utility.Cleanup(setup.DefaultFile)
 	defer func(start time.Time) { // Displays duration of run time
@@ -188,16 +455,7 @@ func action(c *cli.Context, sinkType string) error {
 
 	mysqlutils.VerifyMysqldump(mysqlDumpPath)                                                      // make sure that mysqldump is installed
 	connect := setup.NewConnection(host, user, port, database, dest, pool, matchTables, skrapePwd) // new connection struct
-	extract := skrape.NewExtract(
-		sinkType,
-		config.NewConfig(
-			connect,
-			awsRegion,
-			kinesisStreamEndpoint,
-			kinesisStreamName,
-			kinesisShardCount,
-		),
-	)
+	extract := skrape.NewExtract(sinkType, newConfig(connect))
 	if !connect.Missing() {
 		log.Error("Missing credentials for database connection")
 		os.Exit(1)
@@ -217,6 +475,262 @@ func action(c *cli.Context, sinkType string) error {
 			utility.ExtractAndAppendCommaDelimitedStrings(exclude),
 		)
 	}
+	extract.UploadRunManifest()
+	extract.Close()
+
+	if failed := extract.Failed(); len(failed) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d table(s) failed: %s", len(failed), strings.Join(failed, ", ")), 1)
+	}
+	return nil
+}
+
+// Check the flags of a sink before anything is exported
+func validate(sinkType string) error {
+	for _, dialect := range utility.ExtractAndAppendCommaDelimitedStrings(s3DDL) {
+		if !utility.StringInSlice(dialect, mysqlutils.Dialects) {
+			return fmt.Errorf("unknown --ddl dialect %s, expected one of %s", dialect, strings.Join(mysqlutils.Dialects, ", "))
+		}
+	}
+	switch sinkType {
+	case "postgres":
+		if !utility.StringInSlice(pgMode, config.PgModes) {
+			return fmt.Errorf("unknown --mode %s", pgMode)
+		}
+	case "mysql":
+		if !utility.StringInSlice(targetMethod, config.MysqlMethods) {
+			return fmt.Errorf("unknown --method %s", targetMethod)
+		}
+		if targetDatabase == "" {
+			targetDatabase = database
+		}
+	case "kinesis":
+		if err := config.CheckPartitionKeys(kinesisPartitionKeys); err != nil {
+			return err
+		}
+		if kinesisRetention < 24 || kinesisRetention > 8760 {
+			return fmt.Errorf("--retention-hours must be between 24 and 8760")
+		}
+		switch kinesisOversize {
+		case config.OversizeS3:
+			if s3Bucket == "" {
+				return fmt.Errorf("--oversize s3 needs a bucket set with --bucket")
+			}
+		case config.OversizeTruncate:
+			if len(kinesisTruncateCols) == 0 {
+				return fmt.Errorf("--oversize truncate needs --truncate-columns")
+			}
+		case config.OversizeDeadLetter:
+			if deadLetterDir == "" {
+				return fmt.Errorf("--oversize dead-letter needs --dead-letter")
+			}
+		case config.OversizeFail, config.OversizeSplit:
+		default:
+			return fmt.Errorf("unknown --oversize %s, expected one of %s", kinesisOversize, strings.Join(config.OversizePolicies, ", "))
+		}
+	case "firehose":
+		if firehoseStreamName == "" {
+			return fmt.Errorf("set the delivery stream with --delivery-stream")
+		}
+	case "kafka":
+		if len(kafkaBrokers) == 0 || kafkaTopic == "" {
+			return fmt.Errorf("set the brokers with --brokers and the topic with --topic")
+		}
+		if !utility.StringInSlice(kafkaAcks, config.KafkaAcks) {
+			return fmt.Errorf("unknown --acks %s", kafkaAcks)
+		}
+		if !utility.StringInSlice(kafkaCompression, config.KafkaCompressions) {
+			return fmt.Errorf("unknown --compression %s", kafkaCompression)
+		}
+		if kafkaIdempotent && kafkaAcks != config.KafkaAcksAll {
+			return fmt.Errorf("--idempotent needs --acks %s", config.KafkaAcksAll)
+		}
+	case "sqs":
+		if sqsQueue == "" {
+			return fmt.Errorf("set the queue with --queue")
+		}
+	case "stdout":
+		log.SetHandler(level.New(text.New(os.Stderr), log.InfoLevel))
+		if table == "" {
+			return fmt.Errorf("the stdout sink exports a single table, set it with --table")
+		}
+		if !utility.StringInSlice(rowFormat, config.Formats) {
+			return fmt.Errorf("unknown --format %s", rowFormat)
+		}
+	case "exec":
+		if execCommand == "" {
+			return fmt.Errorf("set the command to run with --command")
+		}
+		if !utility.StringInSlice(rowFormat, config.Formats) {
+			return fmt.Errorf("unknown --format %s", rowFormat)
+		}
+	case "sqlite":
+		if !cgoEnabled {
+			return fmt.Errorf("this skrape binary was built without cgo, which the sqlite sink needs. Rebuild it with CGO_ENABLED=1")
+		}
+	case "http":
+		if httpURL == "" {
+			return fmt.Errorf("set the webhook URL with --http-url")
+		}
+		for _, h := range httpHeaders {
+			if !strings.Contains(h, ":") {
+				return fmt.Errorf("--header %q is not 'Name: value'", h)
+			}
+		}
+	case "tee":
+		sinks := utility.ExtractAndAppendCommaDelimitedStrings(teeSinks)
+		if len(sinks) == 0 {
+			return fmt.Errorf("list the sinks to write to with --sinks")
+		}
+		seen := map[string]bool{}
+		for _, s := range sinks {
+			if !utility.StringInSlice(s, SinkTypes) {
+				return fmt.Errorf("unknown sink %s", s)
+			}
+			if seen[s] {
+				return fmt.Errorf("sink %s is listed more than once", s)
+			}
+			seen[s] = true
+			if err := validate(s); err != nil {
+				return err
+			}
+		}
+		// both write <export-path>/<table>.csv and would overwrite each other
+		if seen["csv"] && seen["s3"] && !s3Stream {
+			return fmt.Errorf("the csv and s3 sinks can only be combined with --stream")
+		}
+	}
+	return nil
+}
+
+// Combine flag sets, keeping the first flag of each name
+func mergeFlags(sets ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	seen := map[string]bool{}
+	for _, set := range sets {
+		for _, f := range set {
+			if !seen[f.GetName()] {
+				seen[f.GetName()] = true
+				flags = append(flags, f)
+			}
+		}
+	}
+	return flags
+}
+
+// Print the DDL for the selected tables to stdout. Logging
+// moves to stderr so the output can be redirected to a file.
+func ddlAction(c *cli.Context) error {
+	log.SetHandler(level.New(text.New(os.Stderr), log.InfoLevel))
+	defer utility.Cleanup(setup.DefaultFile)
+	if !utility.StringInSlice(ddlDialect, mysqlutils.Dialects) {
+		return cli.NewExitError(fmt.Sprintf("unknown --dialect %s, expected one of %s", ddlDialect, strings.Join(mysqlutils.Dialects, ", ")), 1)
+	}
+
+	connect := setup.NewConnection(host, user, port, database, dest, pool, matchTables, skrapePwd)
+	if !connect.Missing() {
+		log.Error("Missing credentials for database connection")
+		os.Exit(1)
+	}
+	setup.MysqlDefaults(skrapePwd)
 
+	tableNames := []string{table}
+	if table == "" {
+		tableNames = skrape.NewExtract("ddl", newConfig(connect)).TableNames(
+			utility.ExtractAndAppendCommaDelimitedStrings(priority),
+			utility.ExtractAndAppendCommaDelimitedStrings(exclude),
+		)
+	}
+	for _, name := range tableNames {
+		schema, _ := mysqlutils.TableSchema(connect, name)
+		if len(schema.Fields) == 0 {
+			return cli.NewExitError(fmt.Sprintf("Table %s not found in %s", name, database), 1)
+		}
+		ddl, err := schema.DDL(ddlDialect, name)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println(ddl)
+	}
 	return nil
 }
+
+// Build the config shared by every command from the cli flags
+func newConfig(connect *setup.Connection) config.Config {
+	return config.NewConfig(
+		connect,
+		runID,
+		config.NewAws(awsRegion),
+		config.NewKinesis(
+			kinesisStreamEndpoint,
+			kinesisStreamName,
+			kinesisShardCount,
+			kinesisAggregate,
+			kinesisPartitionKeys,
+			kinesisRetries,
+			kinesisRateLimit,
+			kinesisReshard,
+			kinesisMBPerShard,
+			kinesisRetention,
+			kinesisKmsKey,
+			kinesisTags,
+			kinesisOversize,
+			utility.ExtractAndAppendCommaDelimitedStrings(kinesisTruncateCols),
+		),
+		config.NewFirehose(firehoseEndpoint, firehoseStreamName),
+		config.NewKafka(
+			utility.ExtractAndAppendCommaDelimitedStrings(kafkaBrokers),
+			kafkaTopic,
+			kafkaAcks,
+			kafkaCompression,
+			kafkaBatchSize,
+			kafkaLinger,
+			kafkaIdempotent,
+			kafkaCreateTopic,
+			kafkaPartitions,
+			kafkaReplication,
+		),
+		config.NewSqs(sqsEndpoint, sqsQueue, sqsGroupColumn, sqsRowsPerMessage),
+		config.NewDynamodb(dynamodbEndpoint, dynamodbTable, dynamodbCreateTable),
+		config.NewElasticsearch(esURL, esIndex, esAlias, esCreateIndex, esBulkSize, esDeleteOld),
+		config.NewS3(
+			s3Bucket,
+			s3Prefix,
+			s3KeyTemplate,
+			s3Manifest,
+			utility.ExtractAndAppendCommaDelimitedStrings(s3DDL),
+			s3Stream,
+			s3PartSize,
+			s3Concurrency,
+			s3MaxRetries,
+		),
+		config.NewPostgres(pgURL, pgSchema, pgMode),
+		config.NewMysqlTarget(
+			targetHost,
+			targetPort,
+			targetUser,
+			targetPwd,
+			targetDatabase,
+			targetMethod,
+			targetBatchSize,
+			targetRecreate,
+			targetTruncate,
+			targetDisableKeys,
+		),
+		config.NewSqlite(sqlitePath, sqliteBatchSize),
+		config.NewStdout(rowFormat),
+		config.NewExec(execCommand, rowFormat),
+		config.NewHttp(
+			httpURL,
+			httpBatchSize,
+			httpBatchBytes,
+			httpGzip,
+			httpHeaders,
+			httpToken,
+			httpBasicAuth,
+			httpRetries,
+		),
+		config.NewTee(utility.ExtractAndAppendCommaDelimitedStrings(teeSinks), teeFailFast),
+		config.NewDeadLetter(deadLetterDir, deadLetterUpload, maxRejects),
+		config.NewTypes(decimals, jsonColumns, timezone, utility.ExtractAndAppendCommaDelimitedStrings(columnTypes)),
+	)
+}